@@ -32,12 +32,24 @@ type Migration struct {
 	DestinationQuery      string `yaml:"destinationQuery"`      // how to insert the data
 	DestinationQueryNArgs int    `yaml:"destinationQueryNArgs"` // number of arguments the destination query takes
 	TransformationScript  string `yaml:"transformationScript"`  // js script for specialized data processing
+
+	// queries and scripts may be kept in files, paths are relative
+	// to the project file or project directory
+	SourceQueryFile          string `yaml:"sourceQueryFile,omitempty"`
+	SourceCountQueryFile     string `yaml:"sourceCountQueryFile,omitempty"`
+	DestinationQueryFile     string `yaml:"destinationQueryFile,omitempty"`
+	TransformationScriptFile string `yaml:"transformationScriptFile,omitempty"`
 }
 
-// TunnelAuth defines tunnel authentication method
-// TODO: Support ssh keys (currently only ssh agent)
+// TunnelAuth defines tunnel authentication methods. Methods are
+// tried in order, the default order is agent, key then password.
+// Secrets are never stored, they are read from environment variables.
 type TunnelAuth struct {
-	User string
+	User          string
+	Methods       []string // agent, key and/or password
+	KeyFile       string   `yaml:"keyFile"`       // path to a private key file
+	PassphraseEnv string   `yaml:"passphraseEnv"` // env var holding the private key passphrase
+	PasswordEnv   string   `yaml:"passwordEnv"`   // env var holding the ssh password
 }
 
 // Endpoint contains a host and port tunnel endpoint.
@@ -46,13 +58,34 @@ type Endpoint struct {
 	Port int
 }
 
-// Tunnel defines an ssh tunnel
-type Tunnel struct {
-	Component  Component
-	Local      Endpoint
+// HostKey defines how an ssh server host key is verified. A pinned
+// Fingerprint takes precedence over the KnownHostsFile.
+type HostKey struct {
+	KnownHostsFile string `yaml:"knownHostsFile"` // defaults to ~/.ssh/known_hosts
+	Fingerprint    string // pinned SHA256 fingerprint, ex: SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8
+	Insecure       bool   // skip host key verification
+}
+
+// JumpHost is an ssh server a tunnel connects through on the way
+// to its Server. Each jump host authenticates separately.
+type JumpHost struct {
 	Server     Endpoint
-	Remote     Endpoint
 	TunnelAuth TunnelAuth `yaml:"tunnelAuth"`
+	HostKey    HostKey    `yaml:"hostKey"`
+}
+
+// Tunnel defines an ssh tunnel. When JumpHosts are defined the
+// connection to Server is made through each jump host in order.
+type Tunnel struct {
+	Component      Component
+	Local          Endpoint
+	JumpHosts      []JumpHost `yaml:"jumpHosts,omitempty"`
+	Server         Endpoint
+	Remote         Endpoint
+	TunnelAuth     TunnelAuth `yaml:"tunnelAuth"`
+	HostKey        HostKey    `yaml:"hostKey"`
+	ConnectTimeout int        `yaml:"connectTimeout"` // seconds, defaults to 10
+	KeepAlive      int        `yaml:"keepAlive"`      // seconds between keepalive requests, defaults to 30
 }
 
 // migrationLog file writer
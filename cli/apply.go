@@ -0,0 +1,439 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/desertbit/grumble"
+	"github.com/txn2/dmk/cfg"
+	"github.com/txn2/dmk/migrate"
+	"gopkg.in/yaml.v2"
+)
+
+func init() {
+	applyCmd := &grumble.Command{
+		Name:  "apply",
+		Help:  "create or update databases, migrations and tunnels from a file",
+		Usage: "apply -f FILE",
+		Flags: func(f *grumble.Flags) {
+			f.String("f", "file", "", "component or project file")
+			f.Bool("n", "dry-run", false, "show changes without saving")
+		},
+		Run: func(c *grumble.Context) error {
+			if c.Flags.String("file") == "" {
+				return errors.New("missing -f FILE, try: " + c.Command.Usage)
+			}
+			if ok := activeProjectCheck(); ok == false {
+				return errors.New("no active project")
+			}
+
+			changes, err := readComponents(c.Flags.String("file"))
+			if err != nil {
+				return err
+			}
+
+			return applyChanges(changes, c.Flags.Bool("dry-run"))
+		},
+	}
+
+	Cli.AddCommand(applyCmd)
+
+}
+
+// componentChange is a database, migration or tunnel to create or
+// update in the active project
+type componentChange struct {
+	Kind        string // Database, Migration or Tunnel
+	MachineName string
+	Component   interface{} // cfg.Database, cfg.Migration or cfg.Tunnel
+}
+
+// readComponents reads the components of a component file
+func readComponents(filename string) ([]componentChange, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// a component file is either a single component with a component
+	// kind and machine name or a set of components in project sections
+	file := migrate.Project{}
+	err = yaml.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	changes := make([]componentChange, 0)
+
+	if file.Component.Kind != "" {
+		if file.Component.MachineName == "" {
+			return nil, fmt.Errorf("%s: component has no machineName", filename)
+		}
+
+		var component interface{}
+
+		switch file.Component.Kind {
+		case "Database":
+			database := cfg.Database{}
+			err = yaml.Unmarshal(data, &database)
+			component = database
+		case "Migration":
+			migration := cfg.Migration{}
+			err = yaml.Unmarshal(data, &migration)
+			if err == nil {
+				err = loadMigrationFiles(&migration, filepath.Dir(filename))
+			}
+			component = migration
+		case "Tunnel":
+			t := cfg.Tunnel{}
+			err = yaml.Unmarshal(data, &t)
+			component = t
+		default:
+			return nil, fmt.Errorf("%s: unknown component kind %s, use Database, Migration or Tunnel", filename, file.Component.Kind)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+
+		return append(changes, componentChange{
+			Kind:        file.Component.Kind,
+			MachineName: file.Component.MachineName,
+			Component:   component,
+		}), nil
+	}
+
+	for _, k := range file.DatabaseNames() {
+		database := file.Databases[k]
+		database.Component = componentDefaults(database.Component, "Database", k)
+		changes = append(changes, componentChange{Kind: "Database", MachineName: k, Component: database})
+	}
+
+	for _, k := range file.TunnelNames() {
+		t := file.Tunnels[k]
+		t.Component = componentDefaults(t.Component, "Tunnel", k)
+		changes = append(changes, componentChange{Kind: "Tunnel", MachineName: k, Component: t})
+	}
+
+	for _, k := range file.MigrationNames() {
+		migration := file.Migrations[k]
+		migration.Component = componentDefaults(migration.Component, "Migration", k)
+		if err := loadMigrationFiles(&migration, filepath.Dir(filename)); err != nil {
+			return nil, fmt.Errorf("%s: migration %s: %s", filename, k, err)
+		}
+		changes = append(changes, componentChange{Kind: "Migration", MachineName: k, Component: migration})
+	}
+
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("%s: no databases, migrations or tunnels found", filename)
+	}
+
+	return changes, nil
+}
+
+// loadMigrationFiles loads the queries and scripts a migration
+// references by path, relative to dir, and references them by their
+// path relative to the project. Saving leaves the files as loaded.
+func loadMigrationFiles(migration *cfg.Migration, dir string) error {
+	refs := []struct {
+		file    *string
+		content *string
+	}{
+		{&migration.SourceQueryFile, &migration.SourceQuery},
+		{&migration.SourceCountQueryFile, &migration.SourceCountQuery},
+		{&migration.DestinationQueryFile, &migration.DestinationQuery},
+		{&migration.TransformationScriptFile, &migration.TransformationScript},
+	}
+
+	for _, ref := range refs {
+		if *ref.file == "" {
+			continue
+		}
+
+		file := *ref.file
+		if filepath.IsAbs(file) == false {
+			file = filepath.Join(dir, file)
+		}
+
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		path, err := projectPath(file)
+		if err != nil {
+			return err
+		}
+
+		*ref.file = path
+		*ref.content = string(data)
+	}
+
+	return nil
+}
+
+// componentDefaults sets the kind and machine name of a component
+// keyed by machine name in a project section
+func componentDefaults(component cfg.Component, kind string, machineName string) cfg.Component {
+	if component.Kind == "" {
+		component.Kind = kind
+	}
+	component.MachineName = machineName
+
+	return component
+}
+
+// applyChanges creates or updates components in the active project,
+// printing the difference for each, and saves the project if any
+// component changed and the project passes validation. Applying the
+// same changes again changes nothing.
+func applyChanges(changes []componentChange, dryRun bool) error {
+	project := appState.Project
+	project.Databases = make(map[string]cfg.Database, len(appState.Project.Databases))
+	project.Migrations = make(map[string]cfg.Migration, len(appState.Project.Migrations))
+	project.Tunnels = make(map[string]cfg.Tunnel, len(appState.Project.Tunnels))
+
+	for k, v := range appState.Project.Databases {
+		project.Databases[k] = v
+	}
+	for k, v := range appState.Project.Migrations {
+		project.Migrations[k] = v
+	}
+	for k, v := range appState.Project.Tunnels {
+		project.Tunnels[k] = v
+	}
+
+	// components are compared as saved, with references to
+	// environment variables and files not expanded
+	saved := yaml.MapSlice{}
+	err := yaml.Unmarshal([]byte(yamlString(appState.Project)), &saved)
+	if err != nil {
+		return err
+	}
+
+	created, changed := 0, 0
+
+	for _, change := range changes {
+		var exists bool
+
+		switch component := change.Component.(type) {
+		case cfg.Database:
+			_, exists = project.Databases[change.MachineName]
+			project.Databases[change.MachineName] = component
+		case cfg.Migration:
+			_, exists = project.Migrations[change.MachineName]
+			project.Migrations[change.MachineName] = component
+		case cfg.Tunnel:
+			_, exists = project.Tunnels[change.MachineName]
+			project.Tunnels[change.MachineName] = component
+		}
+
+		label := strings.ToLower(change.Kind) + " " + change.MachineName
+
+		before := ""
+		if exists {
+			before = yamlString(savedComponent(saved, strings.ToLower(change.Kind)+"s", change.MachineName))
+		}
+		after := yamlString(change.Component)
+
+		switch {
+		case exists == false:
+			created++
+			fmt.Printf("+ %s (created)\n", label)
+		case before != after:
+			changed++
+			fmt.Printf("~ %s (changed)\n", label)
+		default:
+			fmt.Printf("= %s (unchanged)\n", label)
+			continue
+		}
+
+		for _, line := range diffLines(before, after) {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+
+	fmt.Printf("%d created, %d changed, %d unchanged.\n", created, changed, len(changes)-created-changed)
+
+	if created+changed == 0 {
+		return nil
+	}
+
+	// the project is saved only if it passes the checks of validate
+	errs := make([]string, 0)
+	for _, issue := range migrate.Validate(project, DriverManager) {
+		if issue.Level == migrate.IssueError {
+			errs = append(errs, "  "+issue.Component+": "+issue.Message)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("project %s would have %d errors, nothing was saved:\n%s", project.Component.MachineName, len(errs), strings.Join(errs, "\n"))
+	}
+
+	if dryRun {
+		fmt.Println("NOTICE: dry run, nothing was saved.")
+		return nil
+	}
+
+	filename := migrate.ProjectFile(appState.Directory, project.Component.MachineName)
+	written, err := migrate.SaveProject(filename, project)
+	for _, file := range written {
+		fmt.Printf("Wrote %s\n", file)
+	}
+	if err != nil {
+		return err
+	}
+
+	// reload to expand references in the applied components
+	project, err = migrate.LoadProjectEnv(filename, appState.Env)
+	if err != nil {
+		return err
+	}
+
+	SetProject(project)
+
+	return nil
+}
+
+// savedDatabase returns a database of the active project as saved,
+// with references to environment variables and files not expanded
+func savedDatabase(machineName string) (cfg.Database, bool, error) {
+	database := cfg.Database{}
+
+	if _, ok := appState.Project.Databases[machineName]; ok == false {
+		return database, false, nil
+	}
+
+	saved := yaml.MapSlice{}
+	err := yaml.Unmarshal([]byte(yamlString(appState.Project)), &saved)
+	if err != nil {
+		return database, false, err
+	}
+
+	err = yaml.Unmarshal([]byte(yamlString(savedComponent(saved, "databases", machineName))), &database)
+	if err != nil {
+		return database, false, err
+	}
+
+	return database, true, nil
+}
+
+// savedComponent returns a component of a saved project tree
+func savedComponent(tree yaml.MapSlice, section string, machineName string) interface{} {
+	for _, s := range tree {
+		if s.Key != section {
+			continue
+		}
+
+		components, _ := s.Value.(yaml.MapSlice)
+		for _, c := range components {
+			if c.Key == machineName {
+				return c.Value
+			}
+		}
+	}
+
+	return nil
+}
+
+// yamlString marshals a component for comparison
+func yamlString(v interface{}) string {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return err.Error()
+	}
+
+	return string(data)
+}
+
+// diffLines returns the lines removed from a prefixed with "- " and
+// the lines added in b prefixed with "+ ", in order. Unchanged
+// parent keys of changed lines are included, prefixed with "  ".
+func diffLines(a string, b string) []string {
+	al := strings.Split(strings.TrimRight(a, "\n"), "\n")
+	bl := strings.Split(strings.TrimRight(b, "\n"), "\n")
+	if a == "" {
+		al = nil
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence
+	// of al[i:] and bl[j:]
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := make([]string, 0)
+	parents := make([]diffParent, 0)
+
+	// line adds a line, after the parents not added yet
+	line := func(prefix string, l string) {
+		indent := yamlIndent(l)
+		for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+
+		if prefix == "  " {
+			parents = append(parents, diffParent{line: l, indent: indent})
+			return
+		}
+
+		for i := range parents {
+			if parents[i].shown == false {
+				lines = append(lines, "  "+parents[i].line)
+				parents[i].shown = true
+			}
+		}
+		lines = append(lines, prefix+l)
+	}
+
+	i, j := 0, 0
+	for i < len(al) || j < len(bl) {
+		switch {
+		case i < len(al) && j < len(bl) && al[i] == bl[j]:
+			line("  ", al[i])
+			i++
+			j++
+		case i < len(al) && (j == len(bl) || lcs[i+1][j] >= lcs[i][j+1]):
+			line("- ", al[i])
+			i++
+		default:
+			line("+ ", bl[j])
+			j++
+		}
+	}
+
+	return lines
+}
+
+// diffParent is an unchanged line that may be the parent key of a
+// changed line
+type diffParent struct {
+	line   string
+	indent int
+	shown  bool
+}
+
+// yamlIndent returns the indentation of a yaml line, list items
+// are indented one more than their key
+func yamlIndent(l string) int {
+	trimmed := strings.TrimLeft(l, " ")
+	indent := len(l) - len(trimmed)
+	if strings.HasPrefix(trimmed, "- ") {
+		indent++
+	}
+	return indent
+}
@@ -0,0 +1,258 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/txn2/dmk/cfg"
+	"github.com/txn2/dmk/driver"
+	"github.com/txn2/dmk/migrate"
+)
+
+// TestDiffLines tests removed and added lines are shown in order
+// with their unchanged parent keys.
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want []string
+	}{
+		{
+			name: "unchanged",
+			a:    "a: 1\nb: 2\n",
+			b:    "a: 1\nb: 2\n",
+			want: []string{},
+		},
+		{
+			name: "new",
+			a:    "",
+			b:    "a: 1\nb: 2\n",
+			want: []string{"+ a: 1", "+ b: 2"},
+		},
+		{
+			name: "changed value",
+			a:    "a: 1\nb: 2\nc: 3\n",
+			b:    "a: 1\nb: 4\nc: 3\n",
+			want: []string{"- b: 2", "+ b: 4"},
+		},
+		{
+			name: "parent keys",
+			a:    "driver: mysql\nconfiguration:\n  host: a\n  credentials:\n    username: u\n    password: p\nx: 1\n",
+			b:    "driver: mysql\nconfiguration:\n  host: a\n  credentials:\n    username: v\n    password: p\nx: 1\n",
+			want: []string{
+				"  configuration:",
+				"    credentials:",
+				"-     username: u",
+				"+     username: v",
+			},
+		},
+		{
+			name: "parent shown once",
+			a:    "m:\n  a: 1\n  b: 2\n  c: 3\n",
+			b:    "m:\n  a: 0\n  b: 2\n  c: 4\n",
+			want: []string{"  m:", "-   a: 1", "+   a: 0", "-   c: 3", "+   c: 4"},
+		},
+		{
+			name: "list items",
+			a:    "hosts:\n- a\n- b\n",
+			b:    "hosts:\n- a\n- c\n- d\n",
+			want: []string{"  hosts:", "- - b", "+ - c", "+ - d"},
+		},
+		{
+			name: "removed key",
+			a:    "a: 1\nb:\n  c: 2\nd: 3\n",
+			b:    "a: 1\nd: 3\n",
+			want: []string{"- b:", "-   c: 2"},
+		},
+	}
+
+	for _, tt := range tests {
+		got := diffLines(tt.a, tt.b)
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+// testApplyProject writes files to a temporary directory and opens
+// the project t in it, t-dmk.yml is written if not in files
+func testApplyProject(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "dmk-apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := files["t-dmk.yml"]; ok == false {
+		files["t-dmk.yml"] = testApplyProjectFile
+	}
+
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+
+	appState.Directory = dir + "/"
+	project, err := migrate.LoadProject(filepath.Join(dir, "t-dmk.yml"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	SetProject(project)
+
+	return dir, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+// testApplyProjectFile is a project with a migration referencing its
+// script by path
+const testApplyProjectFile = `component: {kind: Project, machineName: t}
+databases:
+  db: {driver: debug, configuration: {}}
+migrations:
+  m: {sourceDb: db, destinationDb: db, sourceQuery: "*", destinationQuery: "{{.id}}", transformationScriptFile: scripts/m.js}
+`
+
+// TestApplyMigrationFiles tests the files a migration component
+// references are loaded relative to the component file and left as
+// they are by applying it.
+func TestApplyMigrationFiles(t *testing.T) {
+	files := map[string]string{
+		"scripts/m.js":       "sendRecord(getRecord());\n",
+		"components/q.cql":   "SELECT * FROM t\n",
+		"components/m.yml":   "component: {kind: Migration, machineName: m, description: changed}\nsourceDb: db\ndestinationDb: db\nsourceQuery: \"*\"\ndestinationQuery: \"{{.id}}\"\ntransformationScriptFile: ../scripts/m.js\n",
+		"components/q.yml":   "migrations:\n  q: {sourceDb: db, destinationDb: db, sourceQueryFile: q.cql, destinationQuery: \"{{.id}}\"}\n",
+		"components/bad.yml": "migrations:\n  bad: {sourceDb: db, destinationDb: db, sourceQueryFile: missing.cql}\n",
+	}
+
+	dir, done := testApplyProject(t, files)
+	defer done()
+
+	tests := []struct {
+		component string
+		migration string
+		file      string // file referenced by the migration, relative to the project
+		want      string // content of the file and the migration
+		wantErr   bool
+	}{
+		{"components/m.yml", "m", "scripts/m.js", files["scripts/m.js"], false},
+		{"components/q.yml", "q", "components/q.cql", files["components/q.cql"], false},
+		{"components/bad.yml", "bad", "", "", true},
+	}
+
+	for _, tt := range tests {
+		changes, err := readComponents(filepath.Join(dir, tt.component))
+		if err == nil {
+			err = applyChanges(changes, false)
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %t", tt.component, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, tt.file))
+		if err != nil {
+			t.Fatalf("%s: %s", tt.component, err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s: %s is %q, want %q", tt.component, tt.file, data, tt.want)
+		}
+
+		migration := appState.Project.Migrations[tt.migration]
+		content := migration.TransformationScript
+		path := migration.TransformationScriptFile
+		if migration.SourceQueryFile != "" {
+			content, path = migration.SourceQuery, migration.SourceQueryFile
+		}
+		if content != tt.want || path != tt.file {
+			t.Errorf("%s: migration references %s with %q, want %s with %q", tt.component, path, content, tt.file, tt.want)
+		}
+	}
+
+	if got := appState.Project.Migrations["m"].Component.Description; got != "changed" {
+		t.Errorf("migration m description is %q, want %q", got, "changed")
+	}
+}
+
+// TestApplyValidation tests changes leaving the project with
+// validation errors are not saved.
+func TestApplyValidation(t *testing.T) {
+	dir, done := testApplyProject(t, map[string]string{"scripts/m.js": "sendRecord(getRecord());\n"})
+	defer done()
+
+	tests := []struct {
+		name    string
+		change  componentChange
+		wantErr bool
+	}{
+		{
+			name: "missing source database",
+			change: componentChange{Kind: "Migration", MachineName: "m2", Component: cfg.Migration{
+				SourceDb: "missing", DestinationDb: "db", SourceQuery: "*", DestinationQuery: "{{.id}}",
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "database configuration",
+			change:  componentChange{Kind: "Database", MachineName: "db2", Component: cfg.Database{Driver: "mysql", Configuration: driver.Config{}}},
+			wantErr: true,
+		},
+		{
+			name: "tunnel endpoint",
+			change: componentChange{Kind: "Tunnel", MachineName: "t", Component: cfg.Tunnel{
+				Remote: cfg.Endpoint{Host: "db", Port: 3306},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "tunnel auth method",
+			change: componentChange{Kind: "Tunnel", MachineName: "t", Component: cfg.Tunnel{
+				Server:     cfg.Endpoint{Host: "bastion", Port: 22},
+				Remote:     cfg.Endpoint{Host: "db", Port: 3306},
+				TunnelAuth: cfg.TunnelAuth{Methods: []string{"agnet"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "valid",
+			change: componentChange{Kind: "Migration", MachineName: "m2", Component: cfg.Migration{
+				SourceDb: "db", DestinationDb: "db", SourceQuery: "*", DestinationQuery: "{{.id}}",
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		before, err := ioutil.ReadFile(filepath.Join(dir, "t-dmk.yml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = applyChanges([]componentChange{tt.change}, false)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %t", tt.name, err, tt.wantErr)
+			continue
+		}
+
+		after, err := ioutil.ReadFile(filepath.Join(dir, "t-dmk.yml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved := string(before) != string(after); saved == tt.wantErr {
+			t.Errorf("%s: project saved is %t", tt.name, saved)
+		}
+	}
+}
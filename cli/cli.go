@@ -9,7 +9,6 @@ import (
 
 	"github.com/desertbit/grumble"
 	"github.com/fatih/color"
-	"github.com/go-yaml/yaml"
 	"github.com/txn2/dmk/driver"
 	"github.com/txn2/dmk/migrate"
 	"github.com/txn2/dmk/tunnel"
@@ -20,7 +19,7 @@ import (
 var appState struct {
 	Project   migrate.Project
 	Directory string // base directory for commandline interaction with projects
-	Env       map[string]string
+	Env       string // environment overlay applied to opened projects
 }
 
 var Version = "0.0.0"
@@ -40,19 +39,51 @@ var Cli = grumble.New(&grumble.Config{
 	Flags: func(f *grumble.Flags) {
 		f.String("p", "project", "", "specify a project")
 		f.String("d", "directory", "./", "specify a directory")
+		f.String("e", "env", "", "specify a project environment overlay")
 	},
 })
 
+// Exit codes, a failed command exits with ExitError unless it returns
+// an exitError
+const (
+	ExitOK            = 0
+	ExitError         = 1 // usage, project and other errors
+	ExitSetupFailed   = 3 // a migration did not start, nothing was written
+	ExitPartialFailed = 4 // a migration failed after it started, records may have been written
+)
+
+// exitError is a command error with an exit code
+type exitError struct {
+	code int
+	err  error
+}
+
+// Error for the error interface
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
+// ExitCode returns the exit code for the error of a command
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	if ee, ok := err.(exitError); ok {
+		return ee.code
+	}
+
+	return ExitError
+}
+
 // DriverManager manages the available database drivers.
 var DriverManager = driver.DriverManager
 
 // TunnelManager manages the available tunnels.
-var TunnelManager = tunnel.Manager{}
+var TunnelManager = tunnel.NewManager()
 
 // init the cmd package
 func init() {
-	appState.Env = make(map[string]string, 0)
-
 	Cli.SetPrintASCIILogo(func(a *grumble.App) {
 		fmt.Println(` Data Migration Kit`)
 		fmt.Println(`  ___  _____ _____ `)
@@ -76,6 +107,7 @@ func init() {
 		}
 
 		appState.Directory = dir
+		appState.Env = flags.String("env")
 
 		Cli.SetPrompt("dmk [" + appState.Directory + "] » ")
 
@@ -108,7 +140,13 @@ func prepDirectory(dir string) (d string, err error) {
 // SetProject sets a project as the active project
 func SetProject(project migrate.Project) {
 	appState.Project = project
-	Cli.SetPrompt("dmk [" + appState.Directory + "] » " + project.Component.MachineName + " » ")
+
+	name := project.Component.MachineName
+	if project.Env() != "" {
+		name += " (" + project.Env() + ")"
+	}
+
+	Cli.SetPrompt("dmk [" + appState.Directory + "] » " + name + " » ")
 }
 
 // activeProjectCheck is a simple check to see if we have a current
@@ -157,11 +195,11 @@ func dbChooser(pcfg PromptCfg) {
 }
 
 // confirmAndSave prompts a user before a save.
-func confirmAndSave(machineName string, component interface{}) bool {
-	filename := machineName + "-dmk.yml"
+func confirmAndSave(machineName string, project migrate.Project) bool {
+	filename := migrate.ProjectFile(appState.Directory, machineName)
 
 	save := false
-	saveMessage := fmt.Sprintf("Save project file %s?", filename)
+	saveMessage := fmt.Sprintf("Save project %s?", filename)
 	savePrompt := &survey.Confirm{
 		Message: saveMessage,
 	}
@@ -174,7 +212,7 @@ func confirmAndSave(machineName string, component interface{}) bool {
 	}
 
 	if exists := fileExists(filename); exists != false {
-		overMessage := fmt.Sprintf("WARNING: Project File %s exists. Overwrite?", filename)
+		overMessage := fmt.Sprintf("WARNING: Project %s exists. Overwrite?", filename)
 		overPrompt := &survey.Confirm{
 			Message: overMessage,
 		}
@@ -188,14 +226,10 @@ func confirmAndSave(machineName string, component interface{}) bool {
 		return false
 	}
 
-	// Marshal to YML and Save
-	d, err := yaml.Marshal(component)
-	if err != nil {
-		fmt.Println(err.Error())
-		return false
+	written, err := migrate.SaveProject(filename, project)
+	for _, file := range written {
+		fmt.Printf("Wrote %s\n", file)
 	}
-
-	err = ioutil.WriteFile(appState.Directory+filename, d, 0644)
 	if err != nil {
 		fmt.Println(err.Error())
 		return false
@@ -1,19 +1,20 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
-
+	"io/ioutil"
+	"net"
+	"path/filepath"
 	"strconv"
-
-	"math/rand"
-
-	"errors"
+	"strings"
 
 	"github.com/AlecAivazis/survey"
 	"github.com/txn2/dmk/cfg"
 	"github.com/txn2/dmk/cliutils"
 	"github.com/txn2/dmk/driver"
 	"github.com/txn2/dmk/migrate"
+	"github.com/txn2/dmk/tunnel"
 	"github.com/desertbit/grumble"
 )
 
@@ -29,18 +30,47 @@ func init() {
 	createCmd.AddCommand(&grumble.Command{
 		Name:    "project",
 		Help:    "create a project",
+		Usage:   "create project [--name NAME ...]",
 		Aliases: []string{"p"},
+		Flags: func(f *grumble.Flags) {
+			componentFlags(f)
+		},
 		Run: func(c *grumble.Context) error {
+			if c.Flags.String("name") != "" {
+				return createProjectFlags(c.Flags)
+			}
 			createProject()
 			return nil
 		},
 	})
 
 	createCmd.AddCommand(&grumble.Command{
-		Name:    "database",
-		Help:    "create a database",
-		Aliases: []string{"db", "d"},
+		Name:      "database",
+		Help:      "create a database",
+		Usage:     "create database [--name NAME --driver DRIVER ... [--set KEY=VALUE ...]]",
+		Aliases:   []string{"db", "d"},
+		AllowArgs: true,
+		Flags: func(f *grumble.Flags) {
+			componentFlags(f)
+			f.StringL("driver", "", "database driver, see \"describe driver DRIVER\" for configuration keys")
+			f.StringL("tunnel", "", "tunnel machine name")
+			// grumble keeps the last value of a repeated flag, --set
+			// ends the flags and is followed by KEY=VALUE [--set KEY=VALUE ...]
+			f.BoolL("set", false, "set a driver configuration KEY=VALUE, repeatable, after the other flags")
+		},
 		Run: func(c *grumble.Context) error {
+			if c.Flags.String("name") != "" {
+				if ok := activeProjectCheck(); ok == false {
+					return errors.New("no active project")
+				}
+
+				values, err := setValues(c.Flags.Bool("set"), c.Args)
+				if err != nil {
+					return err
+				}
+
+				return createDatabaseFlags(c.Flags, values)
+			}
 			if ok := activeProjectCheck(); ok {
 				createDatabase(cfg.Database{})
 			}
@@ -51,8 +81,25 @@ func init() {
 	createCmd.AddCommand(&grumble.Command{
 		Name:    "migration",
 		Help:    "create a migration",
+		Usage:   "create migration [--name NAME --source DB --destination DB ...]",
 		Aliases: []string{"m"},
+		Flags: func(f *grumble.Flags) {
+			componentFlags(f)
+			f.StringL("source", "", "source database machine name")
+			f.StringL("source-query", "", "source query")
+			f.IntL("source-args", -1, "number of source query arguments, defaults to the placeholders in the source query")
+			f.StringL("count-query", "", "source count query")
+			f.StringL("destination", "", "destination database machine name")
+			f.StringL("destination-query", "", "destination query template")
+			f.StringL("script", "", "transformation script file")
+		},
 		Run: func(c *grumble.Context) error {
+			if c.Flags.String("name") != "" {
+				if ok := activeProjectCheck(); ok == false {
+					return errors.New("no active project")
+				}
+				return createMigrationFlags(c.Flags)
+			}
 			if ok := activeProjectCheck(); ok {
 				createMigration()
 			}
@@ -63,8 +110,29 @@ func init() {
 	createCmd.AddCommand(&grumble.Command{
 		Name:    "tunnel",
 		Help:    "create an ssh tunnel",
+		Usage:   "create tunnel [--name NAME --server HOST:PORT --remote HOST:PORT ...]",
 		Aliases: []string{"t"},
+		Flags: func(f *grumble.Flags) {
+			componentFlags(f)
+			f.StringL("local", "localhost:0", "local endpoint, port 0 binds a free port")
+			f.StringL("server", "", "ssh server endpoint")
+			f.StringL("remote", "", "remote endpoint, as seen from the ssh server")
+			f.StringL("user", "", "ssh username")
+			f.StringL("auth", strings.Join(tunnel.DefaultAuthMethods, ","), "comma separated authentication methods")
+			f.StringL("key-file", "", "private key file")
+			f.StringL("passphrase-env", "", "environment variable holding the key passphrase")
+			f.StringL("password-env", "", "environment variable holding the ssh password")
+			f.StringL("known-hosts", "", "known_hosts file")
+			f.StringL("fingerprint", "", "pinned SHA256 host key fingerprint")
+			f.BoolL("insecure", false, "do not verify the server host key")
+		},
 		Run: func(c *grumble.Context) error {
+			if c.Flags.String("name") != "" {
+				if ok := activeProjectCheck(); ok == false {
+					return errors.New("no active project")
+				}
+				return createTunnelFlags(c.Flags)
+			}
 			if ok := activeProjectCheck(); ok {
 				createTunnel()
 			}
@@ -74,6 +142,306 @@ func init() {
 
 }
 
+// componentFlags adds the flags of a component. A create command
+// given a --name does not prompt.
+func componentFlags(f *grumble.Flags) {
+	f.StringL("name", "", "human readable name, create without prompting")
+	f.StringL("machine-name", "", "machine name, defaults to the name with unsafe characters replaced")
+	f.StringL("description", "", "description")
+}
+
+// flagComponent returns the component described by the component
+// flags
+func flagComponent(flags grumble.FlagMap, kind string) cfg.Component {
+	machineName := flags.String("machine-name")
+	if machineName == "" {
+		machineName = cliutils.MachineNameOf(flags.String("name"))
+	}
+
+	return cfg.Component{
+		Kind:        kind,
+		MachineName: machineName,
+		Name:        flags.String("name"),
+		Description: flags.String("description"),
+	}
+}
+
+// createProjectFlags creates a project, or updates the component of
+// an existing project, without prompting
+func createProjectFlags(flags grumble.FlagMap) error {
+	component := flagComponent(flags, "Project")
+	filename := migrate.ProjectFile(appState.Directory, component.MachineName)
+
+	project := migrate.Project{}
+	before := ""
+
+	if fileExists(filename) {
+		var err error
+		project, err = migrate.LoadProjectEnv(filename, appState.Env)
+		if err != nil {
+			return err
+		}
+		before = yamlString(project.Component)
+	}
+
+	project.Component = component
+	after := yamlString(project.Component)
+
+	switch {
+	case before == "":
+		fmt.Printf("+ project %s (created)\n", component.MachineName)
+	case before != after:
+		fmt.Printf("~ project %s (changed)\n", component.MachineName)
+	default:
+		fmt.Printf("= project %s (unchanged)\n", component.MachineName)
+		SetProject(project)
+		return nil
+	}
+
+	for _, line := range diffLines(before, after) {
+		fmt.Printf("  %s\n", line)
+	}
+
+	written, err := migrate.SaveProject(filename, project)
+	for _, file := range written {
+		fmt.Printf("Wrote %s\n", file)
+	}
+	if err != nil {
+		return err
+	}
+
+	SetProject(project)
+
+	return nil
+}
+
+// setValues returns the KEY=VALUE values of --set flags. The first
+// value follows the --set that ended the flags, args holds the value
+// and any further --set KEY=VALUE pairs.
+func setValues(set bool, args []string) ([]string, error) {
+	values := make([]string, 0)
+
+	if set == false {
+		if len(args) > 0 {
+			return nil, errors.New("unexpected " + args[0] + ", set configuration values with --set KEY=VALUE")
+		}
+		return values, nil
+	}
+
+	args = append([]string{"--set"}, args...)
+
+	for len(args) > 0 {
+		if args[0] != "--set" {
+			return nil, errors.New("unexpected " + args[0] + ", flags go before --set")
+		}
+		if len(args) == 1 || strings.Contains(args[1], "=") == false {
+			return nil, errors.New("--set needs a KEY=VALUE")
+		}
+
+		values = append(values, args[1])
+		args = args[2:]
+	}
+
+	return values, nil
+}
+
+// createDatabaseFlags creates or updates a database without
+// prompting. Driver configuration values are KEY=VALUE strings set
+// over the configuration of an existing database with the same
+// driver.
+func createDatabaseFlags(flags grumble.FlagMap, values []string) error {
+	database := cfg.Database{
+		Component:     flagComponent(flags, "Database"),
+		Driver:        flags.String("driver"),
+		Tunnel:        flags.String("tunnel"),
+		Configuration: driver.Config{},
+	}
+
+	existing, exists, err := savedDatabase(database.Component.MachineName)
+	if err != nil {
+		return err
+	}
+	if exists && existing.Driver == database.Driver && existing.Configuration != nil {
+		database.Configuration = existing.Configuration
+	}
+
+	if database.Driver == "" {
+		return errors.New("missing --driver, drivers are: " + strings.Join(registeredDrivers(), ", "))
+	}
+
+	if _, ok := appState.Project.Tunnels[database.Tunnel]; database.Tunnel != "" && ok == false {
+		return errors.New("tunnel " + database.Tunnel + " does not exist")
+	}
+
+	dbDriver, err := DriverManager.GetNewDriver(database.Driver)
+	if err != nil {
+		return err
+	}
+
+	schema := dbDriver.ConfigSchema()
+
+	// collectors are named after their database
+	if database.Driver == "collector" {
+		database.Configuration["collectionKey"] = database.Component.MachineName
+	}
+
+	for _, value := range values {
+		kv := strings.SplitN(value, "=", 2)
+
+		err := schema.Set(database.Configuration, kv[0], kv[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	return applyChanges([]componentChange{{
+		Kind:        "Database",
+		MachineName: database.Component.MachineName,
+		Component:   database,
+	}}, false)
+}
+
+// createMigrationFlags creates or updates a migration without
+// prompting
+func createMigrationFlags(flags grumble.FlagMap) error {
+	migration := cfg.Migration{
+		Component:        flagComponent(flags, "Migration"),
+		SourceDb:         flags.String("source"),
+		SourceQuery:      flags.String("source-query"),
+		SourceQueryNArgs: flags.Int("source-args"),
+		SourceCountQuery: flags.String("count-query"),
+		DestinationDb:    flags.String("destination"),
+		DestinationQuery: flags.String("destination-query"),
+	}
+
+	for _, role := range []string{"source", "destination"} {
+		machineName := flags.String(role)
+		if machineName == "" {
+			return errors.New("missing --" + role + " database")
+		}
+		if _, ok := appState.Project.Databases[machineName]; ok == false {
+			return errors.New(role + " database " + machineName + " does not exist")
+		}
+	}
+
+	if migration.SourceQueryNArgs < 0 {
+		migration.SourceQueryNArgs = 0
+
+		sourceDriver, err := DriverManager.GetNewDriver(appState.Project.Databases[migration.SourceDb].Driver)
+		if err == nil {
+			migration.SourceQueryNArgs = sourceDriver.ArgCount(migration.SourceQuery)
+		}
+	}
+
+	// the script is referenced by its path relative to the project,
+	// the content is kept as loaded so saving leaves the file as is
+	if file := flags.String("script"); file != "" {
+		script, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		path, err := projectPath(file)
+		if err != nil {
+			return err
+		}
+
+		migration.TransformationScriptFile = path
+		migration.TransformationScript = string(script)
+	}
+
+	return applyChanges([]componentChange{{
+		Kind:        "Migration",
+		MachineName: migration.Component.MachineName,
+		Component:   migration,
+	}}, false)
+}
+
+// projectPath returns a path relative to the directory paths of the
+// active project are relative to, or the absolute path if there is
+// no relative path.
+func projectPath(file string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+
+	base := migrate.ProjectBase(migrate.ProjectFile(appState.Directory, appState.Project.Component.MachineName))
+	base, err = filepath.Abs(base)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := filepath.Rel(base, abs)
+	if err != nil {
+		return abs, nil
+	}
+
+	return path, nil
+}
+
+// createTunnelFlags creates or updates a tunnel without prompting
+func createTunnelFlags(flags grumble.FlagMap) error {
+	tunnelCfg := cfg.Tunnel{
+		Component: flagComponent(flags, "Tunnel"),
+		TunnelAuth: cfg.TunnelAuth{
+			User:          flags.String("user"),
+			KeyFile:       flags.String("key-file"),
+			PassphraseEnv: flags.String("passphrase-env"),
+			PasswordEnv:   flags.String("password-env"),
+		},
+		HostKey: cfg.HostKey{
+			KnownHostsFile: flags.String("known-hosts"),
+			Fingerprint:    flags.String("fingerprint"),
+			Insecure:       flags.Bool("insecure"),
+		},
+	}
+
+	for _, method := range strings.Split(flags.String("auth"), ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			tunnelCfg.TunnelAuth.Methods = append(tunnelCfg.TunnelAuth.Methods, method)
+		}
+	}
+
+	endpoints := []struct {
+		flag     string
+		endpoint *cfg.Endpoint
+	}{
+		{"local", &tunnelCfg.Local},
+		{"server", &tunnelCfg.Server},
+		{"remote", &tunnelCfg.Remote},
+	}
+
+	for _, ep := range endpoints {
+		endpoint, err := parseEndpoint(flags.String(ep.flag))
+		if err != nil {
+			return fmt.Errorf("--%s: %s", ep.flag, err)
+		}
+		*ep.endpoint = endpoint
+	}
+
+	return applyChanges([]componentChange{{
+		Kind:        "Tunnel",
+		MachineName: tunnelCfg.Component.MachineName,
+		Component:   tunnelCfg,
+	}}, false)
+}
+
+// parseEndpoint parses a HOST:PORT endpoint
+func parseEndpoint(hostPort string) (cfg.Endpoint, error) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return cfg.Endpoint{}, err
+	}
+
+	portN, err := strconv.Atoi(port)
+	if err != nil {
+		return cfg.Endpoint{}, errors.New("port " + port + " is not a number")
+	}
+
+	return cfg.Endpoint{Host: host, Port: portN}, nil
+}
+
 func createTunnel() {
 	name := ""
 	namePrompt := &survey.Input{
@@ -98,15 +466,16 @@ func createTunnel() {
 		Description: description,
 	}
 
-	fmt.Printf("Configure local endpoint (this machine):\n")
+	fmt.Printf("Configure local endpoint (this machine, port 0 binds a free port when the tunnel opens):\n")
 
-	randPort := strconv.Itoa(3000 + rand.Intn(3000))
-	localEp, err := createEndpoint("Local", "localhost", randPort)
+	localEp, err := createEndpoint("Local", "localhost", "0")
 	if err != nil {
 		Cli.PrintError(err)
 		return
 	}
 
+	jumpHosts := createJumpHosts(nil)
+
 	fmt.Printf("Configure server endpoint (tunnel to):\n")
 	serverEp, err := createEndpoint("Server", "", "22")
 	if err != nil {
@@ -114,12 +483,9 @@ func createTunnel() {
 		return
 	}
 
-	authUser := ""
-	authUserPrompt := &survey.Input{
-		Message: "Server SSH Username",
-		Help:    "Username used for server ssh connection.`",
-	}
-	survey.AskOne(authUserPrompt, &authUser, nil)
+	tunnelAuth := createTunnelAuth(cfg.TunnelAuth{})
+
+	hostKey := createHostKey(cfg.HostKey{})
 
 	fmt.Printf("Configure remote endpoint (destination):\n")
 	remoteEp, err := createEndpoint("Remote", "localhost", "3306")
@@ -131,11 +497,11 @@ func createTunnel() {
 	tunnelCfg := cfg.Tunnel{
 		Component: component,
 		Local:     localEp,
+		JumpHosts: jumpHosts,
 		Server:    serverEp,
 		Remote:    remoteEp,
-		TunnelAuth: cfg.TunnelAuth{
-			User: authUser,
-		},
+		TunnelAuth: tunnelAuth,
+		HostKey:    hostKey,
 	}
 
 	if appState.Project.Tunnels == nil {
@@ -151,6 +517,161 @@ func createTunnel() {
 
 }
 
+func createJumpHosts(current []cfg.JumpHost) []cfg.JumpHost {
+	var jumpHosts []cfg.JumpHost
+
+	if len(current) > 0 {
+		keep := true
+		keepPrompt := &survey.Confirm{
+			Message: fmt.Sprintf("Keep the %d configured jump hosts?", len(current)),
+			Default: true,
+		}
+		survey.AskOne(keepPrompt, &keep, nil)
+
+		if keep {
+			jumpHosts = current
+		}
+	}
+
+	for {
+		addJump := false
+		addJumpPrompt := &survey.Confirm{
+			Message: fmt.Sprintf("Connect through a jump host (%d configured)?", len(jumpHosts)),
+			Help:    "Jump hosts are ssh servers connected through in order before the server endpoint.",
+		}
+		survey.AskOne(addJumpPrompt, &addJump, nil)
+
+		if addJump == false {
+			return jumpHosts
+		}
+
+		fmt.Printf("Configure jump host %d:\n", len(jumpHosts)+1)
+		jumpEp, err := createEndpoint("Jump", "", "22")
+		if err != nil {
+			Cli.PrintError(err)
+			continue
+		}
+
+		jumpHosts = append(jumpHosts, cfg.JumpHost{
+			Server:     jumpEp,
+			TunnelAuth: createTunnelAuth(cfg.TunnelAuth{}),
+			HostKey:    createHostKey(cfg.HostKey{}),
+		})
+	}
+}
+
+func createTunnelAuth(current cfg.TunnelAuth) cfg.TunnelAuth {
+	tunnelAuth := cfg.TunnelAuth{}
+
+	defMethods := strings.Join(tunnel.DefaultAuthMethods, ",")
+	if len(current.Methods) > 0 {
+		defMethods = strings.Join(current.Methods, ",")
+	}
+
+	defKeyFile := "~/.ssh/id_rsa"
+	if current.KeyFile != "" {
+		defKeyFile = current.KeyFile
+	}
+
+	authUserPrompt := &survey.Input{
+		Message: "Server SSH Username",
+		Help:    "Username used for server ssh connection.`",
+		Default: current.User,
+	}
+	survey.AskOne(authUserPrompt, &tunnelAuth.User, nil)
+
+	methods := ""
+	methodsPrompt := &survey.Input{
+		Message: "Authentication Methods:",
+		Help: "Comma separated list of methods to try in order." +
+			"\n agent: keys from the ssh agent (SSH_AUTH_SOCK)" +
+			"\n key: a private key file" +
+			"\n password: a password read from an environment variable",
+		Default: defMethods,
+	}
+	survey.AskOne(methodsPrompt, &methods, nil)
+
+	for _, method := range strings.Split(methods, ",") {
+		method = strings.TrimSpace(method)
+		if method != "" {
+			tunnelAuth.Methods = append(tunnelAuth.Methods, method)
+		}
+
+		switch method {
+		case "key":
+			keyFilePrompt := &survey.Input{
+				Message: "Private Key File:",
+				Default: defKeyFile,
+			}
+			survey.AskOne(keyFilePrompt, &tunnelAuth.KeyFile, nil)
+
+			passphraseEnvPrompt := &survey.Input{
+				Message: "Key Passphrase Environment Variable (optional):",
+				Help:    "Name of the environment variable holding the key passphrase. Ex: `DMK_KEY_PASSPHRASE`",
+				Default: current.PassphraseEnv,
+			}
+			survey.AskOne(passphraseEnvPrompt, &tunnelAuth.PassphraseEnv, nil)
+		case "password":
+			passwordEnvPrompt := &survey.Input{
+				Message: "Password Environment Variable:",
+				Help:    "Name of the environment variable holding the ssh password. Ex: `DMK_SSH_PASSWORD`",
+				Default: current.PasswordEnv,
+			}
+			survey.AskOne(passwordEnvPrompt, &tunnelAuth.PasswordEnv, nil)
+		}
+	}
+
+	return tunnelAuth
+}
+
+func createHostKey(current cfg.HostKey) cfg.HostKey {
+	hostKey := cfg.HostKey{}
+
+	defVerify := "known_hosts"
+	if current.Fingerprint != "" {
+		defVerify = "fingerprint"
+	}
+	if current.Insecure {
+		defVerify = "insecure"
+	}
+
+	defKnownHosts := tunnel.DefaultKnownHostsFile
+	if current.KnownHostsFile != "" {
+		defKnownHosts = current.KnownHostsFile
+	}
+
+	verify := ""
+	verifyPrompt := &survey.Select{
+		Message: "Server Host Key Verification:",
+		Options: []string{"known_hosts", "fingerprint", "insecure"},
+		Default: defVerify,
+		Help: "known_hosts: verify against a known_hosts file" +
+			"\nfingerprint: verify against a pinned SHA256 fingerprint" +
+			"\ninsecure: do not verify the server host key",
+	}
+	survey.AskOne(verifyPrompt, &verify, nil)
+
+	switch verify {
+	case "known_hosts":
+		knownHostsPrompt := &survey.Input{
+			Message: "Known Hosts File:",
+			Default: defKnownHosts,
+		}
+		survey.AskOne(knownHostsPrompt, &hostKey.KnownHostsFile, nil)
+	case "fingerprint":
+		fingerprintPrompt := &survey.Input{
+			Message: "Host Key Fingerprint:",
+			Help:    "Ex: `SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8` (see ssh-keygen -lf)",
+			Default: current.Fingerprint,
+		}
+		survey.AskOne(fingerprintPrompt, &hostKey.Fingerprint, nil)
+	case "insecure":
+		hostKey.Insecure = true
+	}
+
+	return hostKey
+}
+
 func createEndpoint(name string, defH string, defP string) (cfg.Endpoint, error) {
 
 	host := ""
@@ -240,7 +761,7 @@ func createDatabase(database cfg.Database) {
 	// configure the database
 	promptSelect := &survey.Select{
 		Message: "Choose a database driver:",
-		Options: DriverManager.RegisteredDrivers(),
+		Options: registeredDrivers(),
 		Default: database.Driver,
 	}
 	survey.AskOne(promptSelect, &database.Driver, nil)
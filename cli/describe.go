@@ -2,10 +2,12 @@ package cli
 
 import (
 	"fmt"
-
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/txn2/dmk/cfg"
+	"github.com/txn2/dmk/driver"
 	"github.com/txn2/dmk/migrate"
 	"github.com/desertbit/grumble"
 	"github.com/olekukonko/tablewriter"
@@ -87,6 +89,72 @@ func init() {
 		},
 	})
 
+	listCmd.AddCommand(&grumble.Command{
+		Name:      "driver",
+		Help:      "describe the configuration of a database driver",
+		Usage:     "describe driver [machine_name]",
+		Aliases:   []string{"dr"},
+		AllowArgs: true,
+		Run: func(c *grumble.Context) error {
+			if len(c.Args) == 1 {
+				return describeDriver(c.Args[0])
+			}
+			fmt.Printf("Try: %s\n", c.Command.Usage)
+			fmt.Printf("Drivers: %s\n", strings.Join(registeredDrivers(), ", "))
+			return nil
+		},
+	})
+
+}
+
+// describeDriver lists the configuration keys of a driver
+func describeDriver(machineName string) error {
+	d, err := DriverManager.GetNewDriver(machineName)
+	if err != nil {
+		return fmt.Errorf("%s, drivers are: %s", err, strings.Join(registeredDrivers(), ", "))
+	}
+
+	fmt.Println()
+	fmt.Printf("Driver: %s\n", machineName)
+	fmt.Println()
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Key", "Type", "Required", "Default", "Secret", "Help"})
+	table.SetAutoWrapText(false)
+	appendConfigKeys(table, d.ConfigSchema(), "")
+	table.Render()
+	fmt.Println()
+
+	return nil
+}
+
+// appendConfigKeys adds configuration keys, and the keys of nested
+// maps, to a table
+func appendConfigKeys(table *tablewriter.Table, schema driver.ConfigSchema, prefix string) {
+	for _, ck := range schema {
+		help := ck.Help
+		if len(ck.Options) > 0 {
+			help = strings.TrimSpace(help + " One of: " + strings.Join(ck.Options, ", "))
+		}
+
+		table.Append([]string{
+			prefix + ck.Key,
+			ck.Type,
+			fmt.Sprintf("%t", ck.Required),
+			ck.Default,
+			fmt.Sprintf("%t", ck.Secret),
+			help,
+		})
+
+		appendConfigKeys(table, ck.Keys, prefix+ck.Key+".")
+	}
+}
+
+// registeredDrivers returns the sorted driver machine names
+func registeredDrivers() []string {
+	drivers := DriverManager.RegisteredDrivers()
+	sort.Strings(drivers)
+	return drivers
 }
 
 func describeMigration(machineName string) {
@@ -130,7 +198,7 @@ func describeDatabase(machineName string) {
 }
 
 func describeProject(machineName string) {
-	p, err := migrate.LoadProject(appState.Directory + machineName + "-dmk.yml")
+	p, err := migrate.LoadProject(migrate.ProjectFile(appState.Directory, machineName))
 	if err != nil {
 		Cli.PrintError(err)
 		return
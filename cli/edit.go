@@ -2,17 +2,19 @@ package cli
 
 import (
 	"fmt"
+	"strconv"
 
 	"errors"
 
 	"github.com/AlecAivazis/survey"
 	"github.com/desertbit/grumble"
+	"github.com/txn2/dmk/cfg"
 )
 
 func init() {
 	editCmd := &grumble.Command{
 		Name:    "edit",
-		Help:    "edit databases",
+		Help:    "edit databases, migrations, tunnels and projects",
 		Aliases: []string{"e"},
 	}
 
@@ -31,21 +33,62 @@ func init() {
 					return nil
 				}
 
-				dbs := make([]string, 0)
+				editDatabase(chooseComponent("Choose a database to edit:", appState.Project.DatabaseNames()))
 
-				for k := range appState.Project.Databases {
-					dbs = append(dbs, k)
+			}
+			return nil
+		},
+	})
+
+	editCmd.AddCommand(&grumble.Command{
+		Name:      "migration",
+		Help:      "edit a migration",
+		Usage:     "edit migration [machine_name]",
+		Aliases:   []string{"m"},
+		AllowArgs: true,
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok {
+
+				if len(c.Args) == 1 {
+					editMigration(c.Args[0])
+					return nil
 				}
 
-				dbMachineName := ""
-				dbPrompt := &survey.Select{
-					Message: "Choose a database to edit:",
-					Options: dbs,
+				editMigration(chooseComponent("Choose a migration to edit:", appState.Project.MigrationNames()))
+
+			}
+			return nil
+		},
+	})
+
+	editCmd.AddCommand(&grumble.Command{
+		Name:      "tunnel",
+		Help:      "edit a tunnel",
+		Usage:     "edit tunnel [machine_name]",
+		Aliases:   []string{"t"},
+		AllowArgs: true,
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok {
+
+				if len(c.Args) == 1 {
+					editTunnel(c.Args[0])
+					return nil
 				}
-				survey.AskOne(dbPrompt, &dbMachineName, nil)
 
-				editDatabase(dbMachineName)
+				editTunnel(chooseComponent("Choose a tunnel to edit:", appState.Project.TunnelNames()))
+
+			}
+			return nil
+		},
+	})
 
+	editCmd.AddCommand(&grumble.Command{
+		Name:    "project",
+		Help:    "edit the active project",
+		Aliases: []string{"p"},
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok {
+				editProject()
 			}
 			return nil
 		},
@@ -53,6 +96,96 @@ func init() {
 
 }
 
+// Field choices common to every component editor
+const (
+	fieldName        = "Name"
+	fieldDescription = "Description"
+	fieldDone        = "Done (review and save)"
+	fieldCancel      = "Cancel"
+)
+
+// chooseComponent prompts for one of the machine names of a project
+// section
+func chooseComponent(message string, machineNames []string) string {
+	machineName := ""
+	prompt := &survey.Select{
+		Message: message,
+		Options: machineNames,
+	}
+	survey.AskOne(prompt, &machineName, nil)
+
+	return machineName
+}
+
+// chooseField prompts for the next field to edit
+func chooseField(kind string, fields []string) string {
+	field := ""
+	prompt := &survey.Select{
+		Message: "Edit " + kind + ":",
+		Options: append(append([]string{}, fields...), fieldDone, fieldCancel),
+	}
+	survey.AskOne(prompt, &field, nil)
+
+	return field
+}
+
+// editComponent edits the name and description of a component
+func editComponent(field string, component *cfg.Component) {
+	switch field {
+	case fieldName:
+		prompt := &survey.Input{
+			Message: "Name:",
+			Help:    "Human readable name, the machine name " + component.MachineName + " is not changed.",
+			Default: component.Name,
+		}
+		survey.AskOne(prompt, &component.Name, nil)
+	case fieldDescription:
+		prompt := &survey.Input{
+			Message: "Description:",
+			Default: component.Description,
+		}
+		survey.AskOne(prompt, &component.Description, nil)
+	}
+}
+
+// editText opens a value in $EDITOR
+func editText(message string, help string, value *string) {
+	edited := ""
+	prompt := &survey.Editor{
+		Message:       message,
+		Help:          help,
+		Default:       *value,
+		HideDefault:   true,
+		AppendDefault: true,
+	}
+
+	err := survey.AskOne(prompt, &edited, nil)
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+
+	*value = edited
+}
+
+// reviewChanges prints the changes made to a component and returns
+// true if there are any
+func reviewChanges(label string, before interface{}, after interface{}) bool {
+	lines := diffLines(yamlString(before), yamlString(after))
+
+	if len(lines) == 0 {
+		fmt.Printf("NOTICE: %s was not changed.\n", label)
+		return false
+	}
+
+	fmt.Printf("~ %s (changed)\n", label)
+	for _, line := range lines {
+		fmt.Printf("  %s\n", line)
+	}
+
+	return true
+}
+
 func editDatabase(machineName string) {
 	fmt.Printf("Edit database %s\n", machineName)
 
@@ -63,3 +196,209 @@ func editDatabase(machineName string) {
 
 	Cli.PrintError(errors.New("can't find database: " + machineName))
 }
+
+// migrationFields are the editable fields of a migration
+var migrationFields = []string{
+	fieldName,
+	fieldDescription,
+	"Source database",
+	"Source query",
+	"Source query arguments",
+	"Source count query",
+	"Transformation script",
+	"Destination database",
+	"Destination query",
+}
+
+// editMigration edits the fields of a migration one at a time,
+// queries and scripts are opened in $EDITOR
+func editMigration(machineName string) {
+	current, ok := appState.Project.Migrations[machineName]
+	if ok == false {
+		Cli.PrintError(errors.New("can't find migration: " + machineName))
+		return
+	}
+
+	fmt.Printf("Edit migration %s\n", machineName)
+
+	migration := current
+
+	for {
+		field := chooseField("migration "+machineName, migrationFields)
+
+		switch field {
+		case fieldName, fieldDescription:
+			editComponent(field, &migration.Component)
+		case "Source database":
+			dbChooser(PromptCfg{
+				Message: "Choose a SOURCE Database",
+				Value:   &migration.SourceDb,
+				Default: migration.SourceDb,
+			})
+		case "Source query":
+			editText("SOURCE Query:", "Example: `SELECT id, username FROM users WHERE active = ?`", &migration.SourceQuery)
+		case "Source query arguments":
+			nArgs := strconv.Itoa(migration.SourceQueryNArgs)
+			if sdb, ok := appState.Project.Databases[migration.SourceDb]; ok {
+				if d, err := DriverManager.GetNewDriver(sdb.Driver); err == nil && d.HasOutQuery() {
+					nArgs = strconv.Itoa(d.ArgCount(migration.SourceQuery))
+				}
+			}
+			prompt := &survey.Input{
+				Message: "Number of Required Arguments (0 for none):",
+				Help:    "Ex: `The number of ordered arguments to pass to the query.`",
+				Default: nArgs,
+			}
+			survey.AskOne(prompt, &nArgs, func(ans interface{}) error {
+				if _, err := strconv.Atoi(ans.(string)); err != nil {
+					return errors.New("value must be an integer")
+				}
+				return nil
+			})
+			migration.SourceQueryNArgs, _ = strconv.Atoi(nArgs)
+		case "Source count query":
+			editText("SOURCE Count Query:", "Example: `SELECT count(1) as total FROM users WHERE active = ?`", &migration.SourceCountQuery)
+		case "Transformation script":
+			editText("Transformation script in javascript:", "Use javascript to mutate each record before sending.", &migration.TransformationScript)
+		case "Destination database":
+			dbChooser(PromptCfg{
+				Message: "Choose a DESTINATION Database",
+				Value:   &migration.DestinationDb,
+				Default: migration.DestinationDb,
+			})
+		case "Destination query":
+			editText("DESTINATION Query:", "The destination query is run through a template processor, see https://golang.org/pkg/text/template/", &migration.DestinationQuery)
+		case fieldDone:
+			if reviewChanges("migration "+machineName, current, migration) == false {
+				return
+			}
+
+			appState.Project.Migrations[machineName] = migration
+			saved := confirmAndSave(appState.Project.Component.MachineName, appState.Project)
+			if saved {
+				fmt.Println()
+				fmt.Printf("NOTICE: Migration %s was saved.\n", machineName)
+				return
+			}
+
+			// keep the loaded migration if the project was not saved
+			appState.Project.Migrations[machineName] = current
+			return
+		default:
+			fmt.Printf("NOTICE: Migration %s was not changed.\n", machineName)
+			return
+		}
+	}
+}
+
+// tunnelFields are the editable fields of a tunnel
+var tunnelFields = []string{
+	fieldName,
+	fieldDescription,
+	"Local endpoint",
+	"Jump hosts",
+	"Server endpoint",
+	"Authentication",
+	"Host key verification",
+	"Remote endpoint",
+}
+
+// editTunnel edits the fields of a tunnel one at a time
+func editTunnel(machineName string) {
+	current, ok := appState.Project.Tunnels[machineName]
+	if ok == false {
+		Cli.PrintError(errors.New("can't find tunnel: " + machineName))
+		return
+	}
+
+	fmt.Printf("Edit tunnel %s\n", machineName)
+
+	t := current
+
+	for {
+		field := chooseField("tunnel "+machineName, tunnelFields)
+
+		switch field {
+		case fieldName, fieldDescription:
+			editComponent(field, &t.Component)
+		case "Local endpoint":
+			editEndpoint("Local", &t.Local)
+		case "Jump hosts":
+			t.JumpHosts = createJumpHosts(t.JumpHosts)
+		case "Server endpoint":
+			editEndpoint("Server", &t.Server)
+		case "Authentication":
+			t.TunnelAuth = createTunnelAuth(t.TunnelAuth)
+		case "Host key verification":
+			t.HostKey = createHostKey(t.HostKey)
+		case "Remote endpoint":
+			editEndpoint("Remote", &t.Remote)
+		case fieldDone:
+			if reviewChanges("tunnel "+machineName, current, t) == false {
+				return
+			}
+
+			appState.Project.Tunnels[machineName] = t
+			saved := confirmAndSave(appState.Project.Component.MachineName, appState.Project)
+			if saved {
+				fmt.Println()
+				fmt.Printf("NOTICE: Tunnel %s was saved.\n", machineName)
+				return
+			}
+
+			// keep the loaded tunnel if the project was not saved
+			appState.Project.Tunnels[machineName] = current
+			return
+		default:
+			fmt.Printf("NOTICE: Tunnel %s was not changed.\n", machineName)
+			return
+		}
+	}
+}
+
+// editEndpoint prompts for an endpoint with the current values as
+// defaults
+func editEndpoint(name string, endpoint *cfg.Endpoint) {
+	edited, err := createEndpoint(name, endpoint.Host, strconv.Itoa(endpoint.Port))
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+
+	*endpoint = edited
+}
+
+// editProject edits the name and description of the active project
+func editProject() {
+	machineName := appState.Project.Component.MachineName
+	current := appState.Project.Component
+	component := current
+
+	fmt.Printf("Edit project %s\n", machineName)
+
+	for {
+		field := chooseField("project "+machineName, []string{fieldName, fieldDescription})
+
+		switch field {
+		case fieldName, fieldDescription:
+			editComponent(field, &component)
+		case fieldDone:
+			if reviewChanges("project "+machineName, current, component) == false {
+				return
+			}
+
+			project := appState.Project
+			project.Component = component
+			saved := confirmAndSave(machineName, project)
+			if saved {
+				SetProject(project)
+				fmt.Println()
+				fmt.Printf("NOTICE: Project %s was saved.\n", machineName)
+			}
+			return
+		default:
+			fmt.Printf("NOTICE: Project %s was not changed.\n", machineName)
+			return
+		}
+	}
+}
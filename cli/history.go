@@ -0,0 +1,218 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desertbit/grumble"
+	"github.com/olekukonko/tablewriter"
+	"github.com/txn2/dmk/migrate"
+)
+
+func init() {
+	historyCmd := &grumble.Command{
+		Name:      "history",
+		Help:      "list the runs of the active project",
+		Usage:     "history [--limit N] [migration]",
+		Aliases:   []string{"hist"},
+		AllowArgs: true,
+		Flags: func(f *grumble.Flags) {
+			f.Int("", "limit", 20, "Maximum number of runs to list, 0 for no limit.")
+		},
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok == false {
+				return errors.New("no active project")
+			}
+
+			if len(c.Args) > 1 {
+				fmt.Printf("Try: %s\n", c.Command.Usage)
+				return nil
+			}
+
+			migration := ""
+			if len(c.Args) == 1 {
+				migration = c.Args[0]
+			}
+
+			return listHistory(migration, c.Flags.Int("limit"))
+		},
+	}
+
+	Cli.AddCommand(historyCmd)
+
+	historyCmd.AddCommand(&grumble.Command{
+		Name:      "show",
+		Help:      "show a run and the sub-migrations it ran",
+		Usage:     "history show ID",
+		AllowArgs: true,
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok == false {
+				return errors.New("no active project")
+			}
+
+			if len(c.Args) != 1 {
+				fmt.Printf("Try: %s\n", c.Command.Usage)
+				fmt.Println("Try: \"history\" to list runs.")
+				return nil
+			}
+
+			id, err := strconv.ParseUint(c.Args[0], 10, 64)
+			if err != nil {
+				return errors.New("run id must be a number, got " + c.Args[0])
+			}
+
+			return showHistory(id)
+		},
+	})
+
+}
+
+// projectHistory returns the run history of the active project
+func projectHistory() *migrate.History {
+	return migrate.NewHistory(migrate.HistoryDbFile(appState.Directory, appState.Project.Component.MachineName))
+}
+
+// listHistory prints the runs of the active project, newest first
+func listHistory(migration string, limit int) error {
+	entries, err := projectHistory().List(migration, limit)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("NOTICE: no runs recorded.")
+		return nil
+	}
+
+	printHistoryTable(entries)
+
+	if limit > 0 && len(entries) == limit {
+		fmt.Printf("Showing the last %d runs, see --limit.\n", limit)
+	}
+
+	return nil
+}
+
+// showHistory prints a run and the runs it started
+func showHistory(id uint64) error {
+	history := projectHistory()
+
+	entry, err := history.Get(id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Run %d of migration %s\n", entry.ID, entry.Migration)
+	if entry.ParentID != 0 {
+		fmt.Printf("  %-11s run %d, see \"history show %d\"\n", "Parent:", entry.ParentID, entry.ParentID)
+	}
+	fmt.Printf("  %-11s %s\n", "Args:", strings.Join(entry.Args, " "))
+	if entry.Env != "" {
+		fmt.Printf("  %-11s %s\n", "Env:", entry.Env)
+	}
+	if entry.DryRun {
+		fmt.Printf("  %-11s yes\n", "Dry run:")
+	}
+	fmt.Printf("  %-11s %s\n", "Version:", entry.Version)
+	fmt.Printf("  %-11s %s\n", "Started:", entry.Started.Local().Format(time.RFC3339))
+	if entry.Status != migrate.RunRunning {
+		fmt.Printf("  %-11s %s\n", "Finished:", entry.Finished.Local().Format(time.RFC3339))
+	}
+	fmt.Printf("  %-11s %s\n", "Status:", entry.Status)
+	if entry.Error != "" {
+		fmt.Printf("  %-11s %s\n", "Error:", entry.Error)
+	}
+
+	if result := entry.Result; result != nil {
+		fmt.Printf("  %-11s read %d, written %d, skipped %d, failed %d, retried %d\n", "Records:",
+			result.Read, result.Written, result.Skipped, result.Failed, result.Retried)
+
+		switch result.Ended {
+		case migrate.EndedByScript:
+			fmt.Printf("  %-11s early by the script calling end()\n", "Ended:")
+		case migrate.EndedByLimit:
+			fmt.Printf("  %-11s early at the record limit\n", "Ended:")
+		}
+
+		fmt.Printf("  %-11s setup %s, read %s, script %s, template %s, write %s, total %s\n", "Durations:",
+			roundDuration(result.SetupDuration),
+			roundDuration(result.ReadDuration),
+			roundDuration(result.ScriptDuration),
+			roundDuration(result.TemplateDuration),
+			roundDuration(result.WriteDuration),
+			roundDuration(result.Duration),
+		)
+	}
+
+	children, err := history.Children(id)
+	if err != nil {
+		return err
+	}
+
+	if len(children) > 0 {
+		fmt.Println()
+		fmt.Println("Sub-migrations run by the transformation script:")
+		printHistoryTable(children)
+	}
+
+	return nil
+}
+
+// printHistoryTable prints history entries as a table
+func printHistoryTable(entries []migrate.HistoryEntry) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"ID", "Parent", "Migration", "Args", "Started", "Duration", "Read", "Written", "Skipped", "Failed", "Status"})
+	table.SetAutoWrapText(false)
+
+	for _, entry := range entries {
+		parent := ""
+		if entry.ParentID != 0 {
+			parent = strconv.FormatUint(entry.ParentID, 10)
+		}
+
+		duration := "-"
+		if entry.Status != migrate.RunRunning {
+			duration = roundDuration(entry.Finished.Sub(entry.Started))
+		}
+
+		counts := []string{"-", "-", "-", "-"}
+		if result := entry.Result; result != nil {
+			counts = []string{
+				strconv.Itoa(result.Read),
+				strconv.Itoa(result.Written),
+				strconv.Itoa(result.Skipped),
+				strconv.Itoa(result.Failed),
+			}
+		}
+
+		status := entry.Status
+		if entry.DryRun {
+			status += " (dry run)"
+		}
+
+		table.Append(append([]string{
+			strconv.FormatUint(entry.ID, 10),
+			parent,
+			entry.Migration,
+			strings.Join(entry.Args, " "),
+			entry.Started.Local().Format("2006-01-02 15:04:05"),
+			duration,
+		}, append(counts, status)...))
+	}
+
+	table.Render()
+}
+
+// roundDuration formats a duration to milliseconds, or microseconds
+// if shorter
+func roundDuration(d time.Duration) string {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond).String()
+	}
+
+	return d.Round(time.Millisecond).String()
+}
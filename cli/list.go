@@ -3,7 +3,9 @@ package cli
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 
 	"fmt"
 
@@ -90,7 +92,7 @@ func listTunnels() {
 			t.Component.MachineName,
 			fmt.Sprintf("%s: %s", t.Component.Name, t.Component.Description),
 			fmt.Sprintf("%s:%d", t.Local.Host, t.Local.Port),
-			fmt.Sprintf("%s@%s:%d", t.TunnelAuth.User, t.Server.Host, t.Server.Port),
+			tunnelRoute(t),
 			fmt.Sprintf("%s:%d", t.Remote.Host, t.Remote.Port),
 		})
 	}
@@ -132,13 +134,13 @@ func listDatabases() {
 func listProjects() {
 
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Machine Name", "Project", "File Name", "Description"})
+	table.SetHeader([]string{"Machine Name", "Project", "File Name", "Environments", "Description"})
 
 	projects, _ := GetProjects()
 
 	for _, p := range projects {
-		filename := appState.Directory + p.Component.MachineName + "-dmk.yml"
-		table.Append([]string{p.Component.MachineName, p.Component.Name, filename, p.Component.Description})
+		filename := migrate.ProjectFile(appState.Directory, p.Component.MachineName)
+		table.Append([]string{p.Component.MachineName, p.Component.Name, filename, strings.Join(projectEnvs(p.Component.MachineName), ", "), p.Component.Description})
 	}
 
 	table.Render()
@@ -146,6 +148,19 @@ func listProjects() {
 	fmt.Println("Try \"desc p [MACHINE NAME]\" to describe a project.")
 }
 
+// projectEnvs returns the environment overlays of a project
+func projectEnvs(machineName string) []string {
+	prefix := appState.Directory + machineName + "."
+	files, _ := filepath.Glob(prefix + "*-dmk.yml")
+
+	envs := make([]string, 0, len(files))
+	for _, f := range files {
+		envs = append(envs, strings.TrimSuffix(strings.TrimPrefix(f, prefix), "-dmk.yml"))
+	}
+
+	return envs
+}
+
 // GetProjects returns an array slice of projects.
 func GetProjects() (projects []migrate.Project, err error) {
 	files, err := ioutil.ReadDir(appState.Directory)
@@ -158,7 +173,12 @@ func GetProjects() (projects []migrate.Project, err error) {
 
 		// if file ends with -mdk.yml it's a project file
 		// load it and get the name
-		match, _ := regexp.MatchString("-dmk\\.yml$", filename)
+		// project files and directories, environment overlays
+		// (project.env-dmk.yml) are not projects
+		match, _ := regexp.MatchString("^[^.]+-dmk(\\.yml)?$", filename)
+		if match && f.IsDir() != strings.HasSuffix(filename, "-dmk") {
+			match = false
+		}
 
 		if match {
 			project, _ := migrate.LoadProject(appState.Directory + filename)
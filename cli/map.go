@@ -0,0 +1,336 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/AlecAivazis/survey"
+	"github.com/desertbit/grumble"
+	"github.com/olekukonko/tablewriter"
+	"github.com/txn2/dmk/migrate"
+)
+
+func init() {
+	mapCmd := &grumble.Command{
+		Name:    "map",
+		Help:    "inspect, export and import migration value maps",
+		Aliases: []string{"vm"},
+	}
+
+	Cli.AddCommand(mapCmd)
+
+	localDbFlag := func(f *grumble.Flags) {
+		f.String("", "local-db-path", "", "Base path to find local databases.")
+	}
+
+	mapCmd.AddCommand(&grumble.Command{
+		Name:      "list",
+		Help:      "list value maps for a migration",
+		Usage:     "map list MIGRATION",
+		Aliases:   []string{"ls"},
+		AllowArgs: true,
+		Flags:     localDbFlag,
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok {
+				if len(c.Args) == 1 {
+					listValueMaps(c.Args[0], c.Flags.String("local-db-path"))
+					return nil
+				}
+				fmt.Printf("Try: %s\n", c.Command.Usage)
+			}
+			return nil
+		},
+	})
+
+	mapCmd.AddCommand(&grumble.Command{
+		Name:      "get",
+		Help:      "get a value from a value map",
+		Usage:     "map get MIGRATION MAP KEY",
+		AllowArgs: true,
+		Flags:     localDbFlag,
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok {
+				if len(c.Args) == 3 {
+					getValueMapKey(c.Args[0], c.Args[1], c.Args[2], c.Flags.String("local-db-path"))
+					return nil
+				}
+				fmt.Printf("Try: %s\n", c.Command.Usage)
+			}
+			return nil
+		},
+	})
+
+	mapCmd.AddCommand(&grumble.Command{
+		Name:      "export",
+		Help:      "export a value map as csv or jsonl",
+		Usage:     "map export MIGRATION MAP",
+		AllowArgs: true,
+		Flags: func(f *grumble.Flags) {
+			localDbFlag(f)
+			f.String("f", "format", "csv", "Output format (csv or jsonl).")
+			f.String("o", "out", "", "Output file (default standard out).")
+		},
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok {
+				if len(c.Args) == 2 {
+					exportValueMap(c.Args[0], c.Args[1], c.Flags)
+					return nil
+				}
+				fmt.Printf("Try: %s\n", c.Command.Usage)
+			}
+			return nil
+		},
+	})
+
+	mapCmd.AddCommand(&grumble.Command{
+		Name:      "import",
+		Help:      "import key,value rows from a csv file into a value map",
+		Usage:     "map import MIGRATION MAP FILE",
+		AllowArgs: true,
+		Flags: func(f *grumble.Flags) {
+			localDbFlag(f)
+			f.Bool("", "no-header", false, "The first row of the csv file is data, not a header.")
+		},
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok {
+				if len(c.Args) == 3 {
+					importValueMap(c.Args[0], c.Args[1], c.Args[2], c.Flags)
+					return nil
+				}
+				fmt.Printf("Try: %s\n", c.Command.Usage)
+			}
+			return nil
+		},
+	})
+
+	mapCmd.AddCommand(&grumble.Command{
+		Name:      "clear",
+		Help:      "remove a value map and all of its keys",
+		Usage:     "map clear MIGRATION MAP",
+		AllowArgs: true,
+		Flags: func(f *grumble.Flags) {
+			localDbFlag(f)
+			f.Bool("f", "force", false, "Don't ask for confirmation.")
+		},
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok {
+				if len(c.Args) == 2 {
+					clearValueMap(c.Args[0], c.Args[1], c.Flags)
+					return nil
+				}
+				fmt.Printf("Try: %s\n", c.Command.Usage)
+			}
+			return nil
+		},
+	})
+
+}
+
+// openValueMap opens the local value map database of a migration
+// in the active project. The database is only created if create
+// is true.
+func openValueMap(migration string, localDbPath string, create bool) (*migrate.ValueMap, error) {
+	basePath := appState.Directory
+	if localDbPath != "" {
+		basePath = localDbPath
+	}
+
+	dbFile := migrate.LocalDbFile(basePath, appState.Project.Component.MachineName, migration)
+
+	if create == false && fileExists(dbFile) == false {
+		return nil, errors.New("no local database for migration " + migration + " at " + dbFile)
+	}
+
+	return migrate.OpenValueMap(dbFile)
+}
+
+func listValueMaps(migration string, localDbPath string) {
+	vm, err := openValueMap(migration, localDbPath, false)
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+	defer vm.Close()
+
+	names, err := vm.Maps()
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+
+	sort.Strings(names)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Map", "Keys"})
+
+	for _, name := range names {
+		count, err := vm.Count(name)
+		if err != nil {
+			Cli.PrintError(err)
+			return
+		}
+		table.Append([]string{name, strconv.Itoa(count)})
+	}
+
+	table.Render()
+	fmt.Println("Try \"map export " + migration + " [MAP]\" to export a map.")
+}
+
+func getValueMapKey(migration string, name string, key string, localDbPath string) {
+	vm, err := openValueMap(migration, localDbPath, false)
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+	defer vm.Close()
+
+	v, found, err := vm.Get(name, key)
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+
+	if found == false {
+		Cli.PrintError(errors.New("no key " + key + " in map " + name))
+		return
+	}
+
+	fmt.Println(v)
+}
+
+// valueMapRow is a single exported jsonl value map row
+type valueMapRow struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func exportValueMap(migration string, name string, f grumble.FlagMap) {
+	format := f.String("format")
+	if format != "csv" && format != "jsonl" {
+		Cli.PrintError(errors.New("unknown format " + format + ", use csv or jsonl"))
+		return
+	}
+
+	vm, err := openValueMap(migration, f.String("local-db-path"), false)
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+	defer vm.Close()
+
+	var out io.Writer = os.Stdout
+
+	if file := f.String("out"); file != "" {
+		fl, err := os.Create(file)
+		if err != nil {
+			Cli.PrintError(err)
+			return
+		}
+		defer fl.Close()
+		out = fl
+	}
+
+	if format == "jsonl" {
+		enc := json.NewEncoder(out)
+		err = vm.ForEach(name, func(k string, v string) error {
+			return enc.Encode(valueMapRow{Key: k, Value: v})
+		})
+		if err != nil {
+			Cli.PrintError(err)
+		}
+		return
+	}
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"key", "value"})
+	err = vm.ForEach(name, func(k string, v string) error {
+		return w.Write([]string{k, v})
+	})
+	w.Flush()
+
+	if err == nil {
+		err = w.Error()
+	}
+	if err != nil {
+		Cli.PrintError(err)
+	}
+}
+
+func importValueMap(migration string, name string, file string, f grumble.FlagMap) {
+	fl, err := os.Open(file)
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+	defer fl.Close()
+
+	r := csv.NewReader(fl)
+	r.FieldsPerRecord = 2
+
+	rows, err := r.ReadAll()
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+
+	if f.Bool("no-header") == false && len(rows) > 0 {
+		rows = rows[1:]
+	}
+
+	values := make(map[string]string, len(rows))
+	for _, row := range rows {
+		values[row[0]] = row[1]
+	}
+
+	vm, err := openValueMap(migration, f.String("local-db-path"), true)
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+	defer vm.Close()
+
+	err = vm.Import(name, values)
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+
+	fmt.Printf("Imported %d keys into map %s.\n", len(values), name)
+}
+
+func clearValueMap(migration string, name string, f grumble.FlagMap) {
+	clear := f.Bool("force")
+
+	if clear == false {
+		clearPrompt := &survey.Confirm{
+			Message: fmt.Sprintf("Remove map %s and all of its keys from migration %s?", name, migration),
+		}
+		survey.AskOne(clearPrompt, &clear, nil)
+	}
+
+	if clear == false {
+		fmt.Printf("NOTICE: map %s was not cleared.\n", name)
+		return
+	}
+
+	vm, err := openValueMap(migration, f.String("local-db-path"), false)
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+	defer vm.Close()
+
+	err = vm.Clear(name)
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+
+	fmt.Printf("NOTICE: map %s was cleared.\n", name)
+}
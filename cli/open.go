@@ -36,7 +36,7 @@ func init() {
 
 // openProject by machine name
 func openProject(machineName string) {
-	project, err := migrate.LoadProject(appState.Directory + machineName + "-dmk.yml")
+	project, err := migrate.LoadProjectEnv(migrate.ProjectFile(appState.Directory, machineName), appState.Env)
 	if err != nil {
 		Cli.PrintError(err)
 		return
@@ -0,0 +1,269 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/desertbit/grumble"
+	"github.com/olekukonko/tablewriter"
+	"github.com/txn2/dmk/driver"
+	"github.com/txn2/dmk/migrate"
+	"go.uber.org/zap"
+)
+
+func init() {
+	queryCmd := &grumble.Command{
+		Name:      "query",
+		Help:      "run a query against a database and show the records",
+		Usage:     "query [-o table|json|csv] [--limit N] DATABASE [QUERY [ARGS...]]",
+		Aliases:   []string{"q"},
+		AllowArgs: true,
+		Flags: func(f *grumble.Flags) {
+			f.String("o", "output", "table", "Output format: table, json (one record per line) or csv.")
+			f.Int("", "limit", 100, "Maximum number of records to show, 0 for no limit.")
+		},
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok == false {
+				return errors.New("no active project")
+			}
+
+			if len(c.Args) < 1 {
+				fmt.Printf("Try: %s\n", c.Command.Usage)
+				fmt.Println("Try: \"ls d\" to list databases.")
+				return nil
+			}
+
+			query := ""
+			if len(c.Args) > 1 {
+				query = c.Args[1]
+			}
+
+			args := make([]string, 0)
+			if len(c.Args) > 2 {
+				args = c.Args[2:]
+			}
+
+			return queryDatabase(c.Args[0], query, args, c.Flags.String("output"), c.Flags.Int("limit"))
+		},
+	}
+
+	Cli.AddCommand(queryCmd)
+
+}
+
+// recordWriter writes query records in an output format
+type recordWriter interface {
+	Write(record driver.Record) error
+	Flush() error
+}
+
+// queryDatabase configures a database, through its tunnel if it has
+// one, and writes the records of a query. A driver still sending
+// records when the limit is reached is stopped.
+func queryDatabase(machineName string, query string, args []string, output string, limit int) error {
+	var w recordWriter
+
+	switch output {
+	case "table":
+		w = &tableRecordWriter{}
+	case "json":
+		w = &jsonRecordWriter{enc: json.NewEncoder(os.Stdout)}
+	case "csv":
+		w = &csvRecordWriter{w: csv.NewWriter(os.Stdout)}
+	default:
+		return errors.New("unknown output " + output + ", use table, json or csv")
+	}
+
+	rnr := migrate.NewRunner(migrate.RunnerCfg{
+		Project:       appState.Project,
+		DriverManager: DriverManager,
+		TunnelManager: TunnelManager,
+		Path:          appState.Directory,
+		Logger:        zap.NewNop(),
+	})
+
+	// release local databases of collectors
+	defer migrate.CloseLocalDbs()
+
+	d, err := rnr.Database(machineName)
+	if err != nil {
+		return err
+	}
+
+	if d.HasOutQuery() && query == "" {
+		return errors.New("database " + machineName + " needs a query")
+	}
+
+	if n := d.ArgCount(query); d.HasOutQuery() && n != len(args) {
+		return fmt.Errorf("query expects %d args and got %d", n, len(args))
+	}
+
+	records, err := d.Out(query, args)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	limited := false
+
+	for record := range records {
+		if limit > 0 && count == limit {
+			limited = true
+			driver.StopOut(d, records)
+			break
+		}
+
+		err := w.Write(record)
+		if err != nil {
+			driver.StopOut(d, records)
+			return err
+		}
+		count++
+	}
+
+	if limited == false {
+		err = driver.OutError(d)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if output == "table" {
+		fmt.Printf("%d records", count)
+		if limited {
+			fmt.Printf(", limited to %d, see --limit", limit)
+		}
+		fmt.Println(".")
+		return nil
+	}
+
+	if limited {
+		fmt.Fprintf(os.Stderr, "NOTICE: output limited to %d records, see --limit\n", limit)
+	}
+
+	return nil
+}
+
+// recordColumns returns the sorted keys of a record
+func recordColumns(record driver.Record) []string {
+	columns := make([]string, 0, len(record))
+	for k := range record {
+		columns = append(columns, k)
+	}
+
+	sort.Strings(columns)
+	return columns
+}
+
+// recordValue formats a record value for table and csv output
+func recordValue(v interface{}) string {
+	switch tv := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(tv)
+	}
+
+	return fmt.Sprintf("%v", v)
+}
+
+// tableRecordWriter renders records as a table once all records are
+// read. The columns are the keys of every record.
+type tableRecordWriter struct {
+	records []driver.Record
+}
+
+// Write for recordWriter
+func (t *tableRecordWriter) Write(record driver.Record) error {
+	t.records = append(t.records, record)
+	return nil
+}
+
+// Flush for recordWriter
+func (t *tableRecordWriter) Flush() error {
+	keys := make(map[string]bool)
+	for _, record := range t.records {
+		for k := range record {
+			keys[k] = true
+		}
+	}
+
+	columns := make([]string, 0, len(keys))
+	for k := range keys {
+		columns = append(columns, k)
+	}
+	sort.Strings(columns)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(columns)
+	table.SetAutoFormatHeaders(false)
+
+	for _, record := range t.records {
+		row := make([]string, len(columns))
+		for i, k := range columns {
+			if v, ok := record[k]; ok {
+				row[i] = recordValue(v)
+			}
+		}
+		table.Append(row)
+	}
+
+	table.Render()
+	return nil
+}
+
+// jsonRecordWriter writes each record as a line of json
+type jsonRecordWriter struct {
+	enc *json.Encoder
+}
+
+// Write for recordWriter
+func (j *jsonRecordWriter) Write(record driver.Record) error {
+	return j.enc.Encode(record)
+}
+
+// Flush for recordWriter
+func (j *jsonRecordWriter) Flush() error {
+	return nil
+}
+
+// csvRecordWriter writes records as csv with a header of the keys
+// of the first record
+type csvRecordWriter struct {
+	w       *csv.Writer
+	columns []string
+}
+
+// Write for recordWriter
+func (c *csvRecordWriter) Write(record driver.Record) error {
+	if c.columns == nil {
+		c.columns = recordColumns(record)
+		if err := c.w.Write(c.columns); err != nil {
+			return err
+		}
+	}
+
+	row := make([]string, len(c.columns))
+	for i, k := range c.columns {
+		if v, ok := record[k]; ok {
+			row[i] = recordValue(v)
+		}
+	}
+
+	return c.w.Write(row)
+}
+
+// Flush for recordWriter
+func (c *csvRecordWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -35,7 +35,7 @@ func init() {
 func reloadProject(force bool) {
 	machineName := appState.Project.Component.MachineName
 	projectName := appState.Project.Component.Name
-	file := appState.Directory + appState.Project.Component.MachineName + "-dmk.yml"
+	file := migrate.ProjectFile(appState.Directory, appState.Project.Component.MachineName)
 
 	load := force
 
@@ -49,7 +49,7 @@ func reloadProject(force bool) {
 	}
 
 	if load {
-		project, err := migrate.LoadProject(file)
+		project, err := migrate.LoadProjectEnv(file, appState.Project.Env())
 		if err != nil {
 			Cli.PrintError(err)
 			return
@@ -1,10 +1,17 @@
 package cli
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/desertbit/grumble"
+	"github.com/olekukonko/tablewriter"
+	"github.com/txn2/dmk/driver"
 	"github.com/txn2/dmk/migrate"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -18,26 +25,30 @@ func init() {
 		Aliases:   []string{"r"},
 		AllowArgs: true,
 		Flags: func(f *grumble.Flags) {
-			f.Bool("d", "dry-run", false, "Dry run outputs the first 5 records.")
+			f.Bool("d", "dry-run", false, "Preview the first records (see --limit) transformed and their destination queries, nothing is written.")
+			f.String("o", "output", "table", "Dry run output: table or json (one record per line).")
 			f.Bool("v", "verbose", false, "Verbose output.")
 			f.Bool("n", "no-time", false, "Disable timestamps and duration for deterministic output.")
 			f.Bool("l", "log-out", true, "No log file. Log standard out.")
 			f.Bool("q", "quiet", false, "No file logging. Sample status.")
 			f.String("", "local-db-path", "", "Base path to find and create local databases.")
-			f.Int("", "limit", 0, "Limit the number of records to process.")
+			f.Int("", "limit", 0, "Limit the number of source records read, skipped records included, a dry run defaults to 5.")
+			f.Int("", "retries", 0, "Number of times to retry a failed destination write.")
+			f.Int("", "lookup-cache", 0, "Number of script query() results to cache per run.")
+			f.String("", "summary-json", "", "Write a json summary of the run to a file.")
+			f.Bool("", "no-history", false, "Do not record the run in the project run history.")
 		},
 		Run: func(c *grumble.Context) error {
-			if ok := activeProjectCheck(); ok {
-
-				if len(c.Args) > 0 {
-					runMigration(c.Args[0], c.Flags, c.Args[1:])
-					return nil
-				}
-				fmt.Printf("Try: %s\n", c.Command.Usage)
-				fmt.Printf("Try: \"ls m\" for a list or migrations.\n")
-				return nil
+			if ok := activeProjectCheck(); ok == false {
+				return exitError{code: ExitSetupFailed, err: errors.New("no active project")}
+			}
 
+			if len(c.Args) > 0 {
+				return runMigration(c.Args[0], c.Flags, c.Args[1:])
 			}
+
+			fmt.Printf("Try: %s\n", c.Command.Usage)
+			fmt.Printf("Try: \"ls m\" for a list or migrations.\n")
 			return nil
 		},
 	}
@@ -46,8 +57,9 @@ func init() {
 
 }
 
-// runMigration
-func runMigration(machineName string, f grumble.FlagMap, args []string) {
+// runMigration runs a migration. The error of a failed run has
+// the exit code ExitSetupFailed or ExitPartialFailed.
+func runMigration(machineName string, f grumble.FlagMap, args []string) error {
 
 	atom := zap.NewAtomicLevel()
 	encoderCfg := zap.NewProductionEncoderConfig()
@@ -56,14 +68,24 @@ func runMigration(machineName string, f grumble.FlagMap, args []string) {
 		encoderCfg.TimeKey = "" // disable timestamps for deterministic output.
 	}
 
+	output := f.String("output")
+	if f.Bool("dry-run") && output != "table" && output != "json" {
+		return errors.New("unknown output " + output + ", use table or json")
+	}
+
 	var out zapcore.WriteSyncer
 	out = zapcore.Lock(os.Stdout)
 
+	// keep standard out for the previews of a dry run
+	if f.Bool("dry-run") {
+		out = zapcore.Lock(os.Stderr)
+	}
+
 	if f.Bool("log-out") != true && f.Bool("quiet") != true {
 		// log gui data to a file
 		fl, err := fileLog(machineName)
 		if err != nil {
-			panic(err)
+			return exitError{code: ExitSetupFailed, err: err}
 		}
 		defer fl.Close()
 	}
@@ -77,6 +99,10 @@ func runMigration(machineName string, f grumble.FlagMap, args []string) {
 	atom.SetLevel(zap.DebugLevel)
 	defer logger.Sync()
 
+	if env := appState.Project.Env(); env != "" {
+		logger = logger.With(zap.String("Env", env))
+	}
+
 	runnerCfg := migrate.RunnerCfg{
 		Project:       appState.Project,
 		DriverManager: DriverManager,
@@ -87,14 +113,141 @@ func runMigration(machineName string, f grumble.FlagMap, args []string) {
 		Verbose:       f.Bool("verbose"),
 		Quiet:         f.Bool("quiet"),
 		Limit:         f.Int("limit"),
+		Retries:       f.Int("retries"),
 		LocalDbPath:   f.String("local-db-path"),
+		LookupCache:   f.Int("lookup-cache"),
+		NoHistory:     f.Bool("no-history"),
+		Version:       Version,
 		Logger:        logger,
 	}
 
 	rnr := migrate.NewRunner(runnerCfg)
-	_, err := rnr.Run(machineName, args)
+	runResult, runErr := rnr.Run(machineName, args)
+
+	// a run that failed during setup previewed nothing
+	if f.Bool("dry-run") && runResult != nil && (runErr == nil || runResult.SetupDone) {
+		err := printPreviews(runResult.Previews, output)
+		if err != nil {
+			Cli.PrintError(err)
+		}
+	}
+
+	// release value map databases so they are not held open
+	// between runs in the shell
+	err := migrate.CloseLocalDbs()
 	if err != nil {
 		Cli.PrintError(err)
 	}
 
+	if runErr != nil {
+		code := ExitSetupFailed
+		if runResult != nil && runResult.SetupDone {
+			code = ExitPartialFailed
+		}
+		runErr = exitError{code: code, err: runErr}
+	}
+
+	if summaryFile := f.String("summary-json"); summaryFile != "" {
+		err := writeRunSummary(summaryFile, runResult, runErr)
+		if err != nil && runErr == nil {
+			return err
+		}
+		if err != nil {
+			Cli.PrintError(err)
+		}
+	}
+
+	return runErr
+}
+
+// runSummary is the summary of a run written by --summary-json
+type runSummary struct {
+	*migrate.RunResult
+	Project  string `json:"project"`
+	Env      string `json:"env,omitempty"`
+	Version  string `json:"version"`
+	Status   string `json:"status"` // success, setupFailed or partialFailure
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+}
+
+// writeRunSummary writes the result of a run as json
+func writeRunSummary(filename string, runResult *migrate.RunResult, runErr error) error {
+	summary := runSummary{
+		RunResult: runResult,
+		Project:   appState.Project.Component.MachineName,
+		Env:       appState.Project.Env(),
+		Version:   Version,
+		Status:    migrate.RunStatus(runResult, runErr),
+		ExitCode:  ExitCode(runErr),
+	}
+
+	if runErr != nil {
+		summary.Error = runErr.Error()
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	err := enc.Encode(summary)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, b.Bytes(), 0644)
+}
+
+// printPreviews prints the records of a dry run as a table, source
+// and transformed records side by side, or as json lines
+func printPreviews(previews []migrate.Preview, output string) error {
+	if output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		for _, preview := range previews {
+			if err := enc.Encode(preview); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Record", "Source", "Transformed", "Query", "Args"})
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetRowLine(true)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+
+	for _, preview := range previews {
+		row := []string{fmt.Sprintf("%d", preview.Number), previewRecord(preview.Source), "", "", ""}
+
+		switch {
+		case preview.Skipped:
+			row[2] = "(skipped)"
+		case preview.Ended:
+			row[2] = "(end)"
+		default:
+			row[2] = previewRecord(preview.Record)
+			row[3] = preview.Query
+			row[4] = strings.Join(preview.Args, "\n")
+		}
+
+		table.Append(row)
+	}
+
+	table.Render()
+	fmt.Printf("Dry run, %d records previewed, nothing was written.\n", len(previews))
+
+	return nil
+}
+
+// previewRecord formats a record as sorted key: value lines
+func previewRecord(record driver.Record) string {
+	lines := make([]string, 0, len(record))
+	for _, k := range recordColumns(record) {
+		lines = append(lines, k+": "+recordValue(record[k]))
+	}
+
+	return strings.Join(lines, "\n")
 }
@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/AlecAivazis/survey"
+	"github.com/desertbit/grumble"
+	"github.com/txn2/dmk/cfg"
+	"github.com/txn2/dmk/migrate"
+)
+
+// queryFileExt maps database drivers to query file extensions
+var queryFileExt = map[string]string{
+	"cassandra": "cql",
+	"mysql":     "sql",
+}
+
+func init() {
+	splitCmd := &grumble.Command{
+		Name:  "split",
+		Help:  "split the active project file into a project directory",
+		Usage: "split",
+		Flags: func(f *grumble.Flags) {
+			f.Bool("f", "force", false, "Don't ask for confirmation.")
+		},
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok {
+				splitProject(c.Flags.Bool("force"))
+			}
+			return nil
+		},
+	}
+
+	Cli.AddCommand(splitCmd)
+
+}
+
+// splitProject writes the active project as a project directory
+// with databases, migrations and tunnels in their own files and
+// queries and scripts in scripts/ and queries/. The project file
+// is kept as a .bak file.
+func splitProject(force bool) {
+	machineName := appState.Project.Component.MachineName
+	file := migrate.ProjectFile(appState.Directory, machineName)
+	dir := appState.Directory + machineName + "-dmk"
+
+	if file == dir {
+		Cli.PrintError(errors.New("project " + machineName + " is already a project directory: " + dir))
+		return
+	}
+
+	split := force
+
+	if force == false {
+		splitPrompt := &survey.Confirm{
+			Message: fmt.Sprintf("Split %s into %s and rename it to %s.bak?", file, dir, file),
+		}
+		survey.AskOne(splitPrompt, &split, nil)
+	}
+
+	if split == false {
+		fmt.Printf("NOTICE: %s was not split.\n", file)
+		return
+	}
+
+	project := appState.Project
+	project.Migrations = make(map[string]cfg.Migration, len(appState.Project.Migrations))
+
+	for k, m := range appState.Project.Migrations {
+		ext := "txt"
+		if db, ok := project.Databases[m.SourceDb]; ok && queryFileExt[db.Driver] != "" {
+			ext = queryFileExt[db.Driver]
+		}
+		if m.SourceQuery != "" && m.SourceQueryFile == "" {
+			m.SourceQueryFile = "queries/" + k + ".source." + ext
+		}
+		if m.SourceCountQuery != "" && m.SourceCountQueryFile == "" {
+			m.SourceCountQueryFile = "queries/" + k + ".count." + ext
+		}
+
+		ext = "txt"
+		if db, ok := project.Databases[m.DestinationDb]; ok && queryFileExt[db.Driver] != "" {
+			ext = queryFileExt[db.Driver]
+		}
+		if m.DestinationQuery != "" && m.DestinationQueryFile == "" {
+			m.DestinationQueryFile = "queries/" + k + ".destination." + ext
+		}
+
+		if m.TransformationScript != "" && m.TransformationScriptFile == "" {
+			m.TransformationScriptFile = "scripts/" + k + ".js"
+		}
+
+		project.Migrations[k] = m
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+
+	written, err := migrate.SaveProject(dir, project)
+	for _, f := range written {
+		fmt.Printf("Wrote %s\n", f)
+	}
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+
+	err = os.Rename(file, file+".bak")
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+
+	project, err = migrate.LoadProjectEnv(dir, appState.Env)
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+
+	SetProject(project)
+	fmt.Printf("NOTICE: Project %s was split into %s.\n", machineName, dir)
+}
@@ -0,0 +1,190 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/desertbit/grumble"
+	"github.com/olekukonko/tablewriter"
+	"github.com/txn2/dmk/migrate"
+	"go.uber.org/zap"
+)
+
+func init() {
+	testCmd := &grumble.Command{
+		Name: "test",
+		Help: "test database connections",
+	}
+
+	Cli.AddCommand(testCmd)
+
+	testCmd.AddCommand(&grumble.Command{
+		Name:      "database",
+		Help:      "test a database connection",
+		Usage:     "test database [machine_name]",
+		Aliases:   []string{"db", "d"},
+		AllowArgs: true,
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok == false {
+				return errors.New("no active project")
+			}
+
+			if len(c.Args) != 1 {
+				fmt.Printf("Try: %s\n", c.Command.Usage)
+				fmt.Println("Try: \"ls d\" to list databases.")
+				return nil
+			}
+
+			return testDatabases(c.Args)
+		},
+	})
+
+	testCmd.AddCommand(&grumble.Command{
+		Name:    "all",
+		Help:    "test every database connection of the active project",
+		Aliases: []string{"a"},
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok == false {
+				return errors.New("no active project")
+			}
+
+			return testDatabases(appState.Project.DatabaseNames())
+		},
+	})
+
+}
+
+// connectionHints are hints for database check errors containing
+// a text
+var connectionHints = []struct {
+	text string
+	hint string
+}{
+	{"missing config key", "set the key, see \"describe driver\" for the driver configuration"},
+	{"unknown config key", "remove the key or check its spelling, see \"describe driver\""},
+	{"config key", "fix the value, see \"describe driver\" for the driver configuration"},
+	{"environment variable", "export the environment variable before running dmk"},
+	{"host key", "check the tunnel host key, see \"tunnel test\""},
+	{"is not in", "check the tunnel host key, see \"tunnel test\""},
+	{"unable to authenticate", "check the tunnel user and authentication, see \"tunnel test\""},
+	{"ssh server", "check the tunnel server endpoint and jump hosts, see \"tunnel test\""},
+	{"through", "check the tunnel remote endpoint and that the database is running"},
+	{"no such host", "check the host name"},
+	{"connection refused", "check the host and port and that the database is running"},
+	{"timeout", "check the host, port and firewall rules, or use a tunnel"},
+	{"bad connection", "the connection was closed, for a tunneled database check the tunnel remote endpoint"},
+	{"no connections were made", "check the cluster list and that the nodes are running"},
+	{"Access denied", "check the username and password"},
+	{"authentication", "check the username and password"},
+	{"Unknown database", "check the database name"},
+	{"keyspace", "check the keyspace"},
+	{"no such file", "check the file path, relative paths are relative to where dmk runs"},
+	{"header", "the first line of the file must name the columns"},
+}
+
+// connectionHint returns a hint for a database check error
+func connectionHint(err error) string {
+	for _, h := range connectionHints {
+		if strings.Contains(err.Error(), h.text) {
+			return h.hint
+		}
+	}
+
+	return ""
+}
+
+// testDatabases checks the connection of databases, an error is
+// returned if any failed so dmk exits non-zero.
+func testDatabases(machineNames []string) error {
+	if len(machineNames) == 0 {
+		fmt.Println("NOTICE: the project has no databases, try: \"create database\"")
+		return nil
+	}
+
+	rnr := migrate.NewRunner(migrate.RunnerCfg{
+		Project:       appState.Project,
+		DriverManager: DriverManager,
+		TunnelManager: TunnelManager,
+		Path:          appState.Directory,
+		Logger:        zap.NewNop(),
+	})
+
+	// release local databases of collectors
+	defer migrate.CloseLocalDbs()
+
+	checks := make([]migrate.DatabaseCheck, 0, len(machineNames))
+	for _, machineName := range machineNames {
+		fmt.Printf("Testing %s...\n", machineName)
+		checks = append(checks, rnr.CheckDatabase(machineName))
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Database", "Driver", "Tunnel", "Configure", "Ping", "Result"})
+	table.SetAutoWrapText(false)
+
+	failed := make([]migrate.DatabaseCheck, 0)
+
+	for _, check := range checks {
+		tunnelCol := "-"
+		if check.Tunnel != "" {
+			tunnelCol = check.Tunnel + " " + checkTime(check, migrate.CheckTunnel, check.TunnelTime)
+		}
+
+		pingCol := "-"
+		if check.Pinged || check.Stage == migrate.CheckPing {
+			pingCol = checkTime(check, migrate.CheckPing, check.PingTime)
+		}
+
+		result := "ok"
+		if check.Err != nil {
+			result = "FAILED (" + check.Stage + ")"
+			failed = append(failed, check)
+		}
+
+		table.Append([]string{
+			check.MachineName,
+			check.Driver,
+			tunnelCol,
+			checkTime(check, migrate.CheckConfigure, check.ConfigTime),
+			pingCol,
+			result,
+		})
+	}
+
+	table.Render()
+
+	for _, check := range failed {
+		fmt.Printf("%s %s: %s\n", check.MachineName, check.Stage, check.Err)
+		if hint := connectionHint(check.Err); hint != "" {
+			fmt.Printf("  Hint: %s\n", hint)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d databases failed", len(failed), len(checks))
+	}
+
+	return nil
+}
+
+// checkTime formats the time of a check stage, stages after a failed
+// stage did not run
+func checkTime(check migrate.DatabaseCheck, stage string, d time.Duration) string {
+	stages := []string{migrate.CheckTunnel, migrate.CheckConfigure, migrate.CheckPing}
+
+	if check.Err != nil {
+		for _, s := range stages {
+			if s == check.Stage && s != stage {
+				return "-"
+			}
+			if s == stage {
+				break
+			}
+		}
+	}
+
+	return roundDuration(d)
+}
@@ -0,0 +1,199 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/desertbit/grumble"
+	"github.com/olekukonko/tablewriter"
+	"github.com/txn2/dmk/cfg"
+)
+
+func init() {
+	tunnelCmd := &grumble.Command{
+		Name:    "tunnel",
+		Help:    "open, close, test and show the status of tunnels",
+		Aliases: []string{"tun"},
+	}
+
+	Cli.AddCommand(tunnelCmd)
+
+	tunnelCmd.AddCommand(&grumble.Command{
+		Name:      "open",
+		Help:      "open a tunnel",
+		Usage:     "tunnel open TUNNEL",
+		AllowArgs: true,
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok {
+				if len(c.Args) == 1 {
+					openTunnel(c.Args[0])
+					return nil
+				}
+				fmt.Printf("Try: %s\n", c.Command.Usage)
+				fmt.Println("Try: \"ls t\" to list tunnels.")
+			}
+			return nil
+		},
+	})
+
+	tunnelCmd.AddCommand(&grumble.Command{
+		Name:      "close",
+		Help:      "close a running tunnel",
+		Usage:     "tunnel close TUNNEL",
+		AllowArgs: true,
+		Flags: func(f *grumble.Flags) {
+			f.Bool("a", "all", false, "Close all running tunnels.")
+		},
+		Run: func(c *grumble.Context) error {
+			if c.Flags.Bool("all") {
+				err := TunnelManager.CloseAll()
+				if err != nil {
+					Cli.PrintError(err)
+				}
+				return nil
+			}
+
+			if len(c.Args) == 1 {
+				closeTunnel(c.Args[0])
+				return nil
+			}
+			fmt.Printf("Try: %s\n", c.Command.Usage)
+			return nil
+		},
+	})
+
+	tunnelCmd.AddCommand(&grumble.Command{
+		Name:    "status",
+		Help:    "show running tunnels",
+		Usage:   "tunnel status",
+		Aliases: []string{"st"},
+		Run: func(c *grumble.Context) error {
+			tunnelStatus()
+			return nil
+		},
+	})
+
+	tunnelCmd.AddCommand(&grumble.Command{
+		Name:      "test",
+		Help:      "test a tunnel with a connection to its remote endpoint",
+		Usage:     "tunnel test TUNNEL",
+		AllowArgs: true,
+		Run: func(c *grumble.Context) error {
+			if ok := activeProjectCheck(); ok {
+				if len(c.Args) == 1 {
+					testTunnel(c.Args[0])
+					return nil
+				}
+				fmt.Printf("Try: %s\n", c.Command.Usage)
+			}
+			return nil
+		},
+	})
+
+}
+
+// startTunnel starts a tunnel of the active project if it is
+// not already running.
+func startTunnel(machineName string) error {
+	tunnelCfg, ok := appState.Project.Tunnels[machineName]
+	if ok != true {
+		return errors.New("can't find tunnel: " + machineName)
+	}
+
+	return TunnelManager.Tunnel(tunnelCfg)
+}
+
+func openTunnel(machineName string) {
+	if TunnelManager.Running(machineName) {
+		fmt.Printf("Tunnel %s is already open.\n", machineName)
+		return
+	}
+
+	err := startTunnel(machineName)
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+
+	t := appState.Project.Tunnels[machineName]
+	fmt.Printf("Tunnel %s open: %s:%d > %s > %s:%d\n", machineName,
+		t.Local.Host, t.Local.Port, tunnelRoute(t), t.Remote.Host, t.Remote.Port)
+}
+
+// tunnelRoute describes the ssh servers of a tunnel, jump hosts
+// first, as user@host:port separated by " > ".
+func tunnelRoute(t cfg.Tunnel) string {
+	route := make([]string, 0, len(t.JumpHosts)+1)
+	for _, j := range t.JumpHosts {
+		route = append(route, fmt.Sprintf("%s@%s:%d", j.TunnelAuth.User, j.Server.Host, j.Server.Port))
+	}
+	route = append(route, fmt.Sprintf("%s@%s:%d", t.TunnelAuth.User, t.Server.Host, t.Server.Port))
+
+	return strings.Join(route, " > ")
+}
+
+func closeTunnel(machineName string) {
+	err := TunnelManager.Close(machineName)
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+
+	fmt.Printf("Tunnel %s closed.\n", machineName)
+}
+
+func tunnelStatus() {
+	status := TunnelManager.Status()
+
+	if len(status) == 0 {
+		fmt.Println("No tunnels are running.")
+		fmt.Println("Try \"tunnel open [MACHINE NAME]\" to open a tunnel.")
+		return
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Name < status[j].Name
+	})
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Machine Name", "Local >", "Server >", "Remote", "Connections", "Sent", "Received", "Uptime"})
+
+	for _, s := range status {
+		table.Append([]string{
+			s.Name,
+			s.Local,
+			s.Server,
+			s.Remote,
+			strconv.FormatInt(s.Connections, 10),
+			strconv.FormatInt(s.BytesSent, 10),
+			strconv.FormatInt(s.BytesReceived, 10),
+			time.Now().Sub(s.Started).Round(time.Second).String(),
+		})
+	}
+
+	table.Render()
+}
+
+func testTunnel(machineName string) {
+	if TunnelManager.Running(machineName) == false {
+		err := startTunnel(machineName)
+		if err != nil {
+			Cli.PrintError(err)
+			return
+		}
+		fmt.Printf("Tunnel %s opened for test.\n", machineName)
+	}
+
+	d, err := TunnelManager.Test(machineName)
+	if err != nil {
+		Cli.PrintError(err)
+		return
+	}
+
+	fmt.Printf("Tunnel %s OK, remote connection in %s.\n", machineName, d)
+}
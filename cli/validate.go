@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/desertbit/grumble"
+	"github.com/olekukonko/tablewriter"
+	"github.com/txn2/dmk/migrate"
+)
+
+func init() {
+	validateCmd := &grumble.Command{
+		Name:      "validate",
+		Help:      "check a project for errors",
+		Usage:     "validate [project]",
+		Aliases:   []string{"lint"},
+		AllowArgs: true,
+		Run: func(c *grumble.Context) error {
+			if len(c.Args) > 1 {
+				fmt.Printf("Try: %s\n", c.Command.Usage)
+				return nil
+			}
+
+			if len(c.Args) == 1 {
+				project, err := migrate.LoadProjectEnv(migrate.ProjectFile(appState.Directory, c.Args[0]), appState.Env)
+				if err != nil {
+					return err
+				}
+				return validateProject(project)
+			}
+
+			if ok := activeProjectCheck(); ok {
+				return validateProject(appState.Project)
+			}
+
+			return errors.New("no project to validate")
+		},
+	}
+
+	Cli.AddCommand(validateCmd)
+
+}
+
+// validateProject prints the issues found in a project, an error
+// is returned if there are any errors so dmk exits non-zero.
+func validateProject(project migrate.Project) error {
+	issues := migrate.Validate(project, DriverManager)
+
+	errorCount := 0
+	for _, issue := range issues {
+		if issue.Level == migrate.IssueError {
+			errorCount++
+		}
+	}
+
+	if len(issues) == 0 {
+		fmt.Printf("Project %s is valid.\n", project.Component.MachineName)
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Level", "Component", "Problem"})
+	table.SetAutoWrapText(false)
+
+	for _, issue := range issues {
+		table.Append([]string{issue.Level, issue.Component, issue.Message})
+	}
+
+	table.Render()
+
+	fmt.Printf("%d errors, %d warnings.\n", errorCount, len(issues)-errorCount)
+
+	if errorCount > 0 {
+		return fmt.Errorf("project %s has %d errors", project.Component.MachineName, errorCount)
+	}
+
+	return nil
+}
@@ -9,13 +9,9 @@ import (
 )
 
 // MachineName makes a string with unsafe characters replaced
+// and prompts to accept or change it
 func MachineName(name string) string {
-	reg, err := regexp.Compile("[^a-zA-Z0-9]+")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	machineName := strings.ToLower(reg.ReplaceAllString(name, "_"))
+	machineName := MachineNameOf(name)
 
 	prompt := &survey.Input{
 		Message: "Machine Name:",
@@ -29,3 +25,14 @@ func MachineName(name string) string {
 
 	return machineName
 }
+
+// MachineNameOf makes a string with unsafe characters replaced
+// without prompting
+func MachineNameOf(name string) string {
+	reg, err := regexp.Compile("[^a-zA-Z0-9]+")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return strings.ToLower(reg.ReplaceAllString(name, "_"))
+}
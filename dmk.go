@@ -1,10 +1,20 @@
 package main
 
 import (
-	"github.com/desertbit/grumble"
+	"fmt"
+	"os"
+
 	"github.com/txn2/dmk/cli"
 )
 
 func main() {
-	grumble.Main(cli.Cli)
+	err := cli.Cli.Run()
+
+	// stop any tunnels still running when the shell exits
+	cli.TunnelManager.CloseAll()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(cli.ExitCode(err))
+	}
 }
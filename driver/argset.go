@@ -3,10 +3,6 @@ package driver
 import (
 	"errors"
 	"fmt"
-
-	"strings"
-
-	"github.com/AlecAivazis/survey"
 )
 
 // Argset implements data.Driver
@@ -23,14 +19,7 @@ func (a *Argset) ArgCount(query string) int {
 // GetArgs returns the args as a string slice.
 func (a *Argset) GetArgs() []string {
 
-	if argConfig, ok := a.config["args"].([]interface{}); ok {
-		argSet := make([]string, 0)
-
-		// convert interfaces to strings
-		for _, ag := range argConfig {
-			argSet = append(argSet, ag.(string))
-		}
-
+	if argSet, ok := configList(a.config["args"]); ok {
 		return argSet
 	}
 
@@ -57,13 +46,18 @@ func (a *Argset) HasCountQuery() bool {
 	return false
 }
 
-// Configure (keys determined in ConfigSurvey)
-func (a *Argset) Configure(config Config) error {
+// ConfigSchema for Driver interface.
+func (a *Argset) ConfigSchema() ConfigSchema {
+	return ConfigSchema{
+		{Key: "args", Type: ConfigList, Label: "Named Arguments (Comma separated):", Required: true, Help: "Example: Days, Limit"},
+	}
+}
 
-	// Validation
-	_, ok := config["args"]
-	if ok != true {
-		return errors.New("missing config key args")
+// Configure (keys determined in ConfigSchema)
+func (a *Argset) Configure(config Config) error {
+	config, err := a.ConfigSchema().Prepare(config)
+	if err != nil {
+		return err
 	}
 
 	a.config = config
@@ -117,21 +111,7 @@ func (a *Argset) Out(query string, args []string) (<-chan Record, error) {
 func (a *Argset) ConfigSurvey(config Config, machineName string) error {
 	fmt.Println("---- Argset Driver Configuration ----")
 
-	args := ""
-	prompt := &survey.Input{
-		Message: "Named Arguments (Comma separated):",
-		Help:    "Example: Days, Limit",
-	}
-	survey.AskOne(prompt, &args, nil)
-
-	argSlice := strings.Split(args, ",")
-	cleanArgs := make([]string, 0)
-
-	for _, arg := range argSlice {
-		cleanArgs = append(cleanArgs, strings.TrimSpace(arg))
-	}
-
-	config["args"] = cleanArgs
+	a.ConfigSchema().Survey(config)
 
 	return nil
 }
@@ -2,15 +2,13 @@ package driver
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"errors"
 
-	"log"
-
 	"time"
 
-	"github.com/AlecAivazis/survey"
 	"github.com/gocql/gocql"
 )
 
@@ -29,6 +27,8 @@ var ConsistencyLookup = map[gocql.Consistency]string{
 type Cassandra struct {
 	session *gocql.Session
 	config  Config
+	outErr  error         // error of the last Out
+	stop    chan struct{} // closed to stop the last Out
 }
 
 // ArgCount calculate the number of expected arguments for
@@ -57,10 +57,31 @@ func (c *Cassandra) HasCountQuery() bool {
 	return true
 }
 
-// Configure (keys determined in ConfigSurvey)
+// ConfigSchema for Driver interface.
+func (c *Cassandra) ConfigSchema() ConfigSchema {
+	consistencyNames := make([]string, 0)
+	for _, v := range ConsistencyLookup {
+		consistencyNames = append(consistencyNames, v)
+	}
+	sort.Strings(consistencyNames)
+
+	return ConfigSchema{
+		{Key: "clusterList", Type: ConfigString, Label: "Nodes:", Required: true, Help: "Comma separated list of nodes ex: \"n1.example.com,n2.example.com\""},
+		{Key: "keyspace", Type: ConfigString, Label: "Keyspace:", Required: true, Help: "The Cassandra keyspace to query against."},
+		{Key: "consistency", Type: ConfigString, Label: "Choose a Consistency Level:", Default: "LocalQuorum", Options: consistencyNames, Help: "Recorded with the database, queries use LocalQuorum."},
+		{Key: "credentials", Type: ConfigMap, Label: "Does this cluster require login credentials?", Help: "Login credentials.", Keys: ConfigSchema{
+			{Key: "username", Type: ConfigString, Label: "Username:", Required: true, Help: "The Cassandra username."},
+			{Key: "password", Type: ConfigString, Label: "Password:", Required: true, Secret: true, Help: "The Cassandra password."},
+		}},
+	}
+}
+
+// Configure (keys determined in ConfigSchema)
 func (c *Cassandra) Configure(config Config) error {
-	// @TODO improve validation
-	//fmt.Printf("Configuring Cassandra\n")
+	config, err := c.ConfigSchema().Prepare(config)
+	if err != nil {
+		return err
+	}
 
 	// get cluster nodes
 	nodes := strings.Split(config["clusterList"].(string), ",")
@@ -74,16 +95,10 @@ func (c *Cassandra) Configure(config Config) error {
 	cluster.Consistency = gocql.LocalQuorum
 	cluster.Timeout = 10 * time.Second
 
-	if credentialsInt, ok := config["credentials"].(map[interface{}]interface{}); ok {
-		// create u/p slice
-		up := make(map[string]string)
-		for i, v := range credentialsInt {
-			up[i.(string)] = v.(string)
-		}
-
+	if credentials, ok := config["credentials"].(Config); ok {
 		cluster.Authenticator = gocql.PasswordAuthenticator{
-			Username: up["username"],
-			Password: up["password"],
+			Username: credentials["username"].(string),
+			Password: credentials["password"].(string),
 		}
 	}
 
@@ -93,14 +108,26 @@ func (c *Cassandra) Configure(config Config) error {
 	cluster.NumConns = 1
 	session, err := cluster.CreateSession()
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	c.session = session
+	c.config = config
 
 	return nil
 }
 
+// Ping for Pinger interface, queries the release version of the
+// connected node.
+func (c *Cassandra) Ping() error {
+	if c.session == nil {
+		return errors.New("the Cassandra driver is not configured")
+	}
+
+	version := ""
+	return c.session.Query("SELECT release_version FROM system.local").Scan(&version)
+}
+
 // Done for Driver interface.
 func (c *Cassandra) Done() error {
 	return nil
@@ -155,9 +182,12 @@ func (c *Cassandra) Out(query string, args []string) (<-chan Record, error) {
 
 	itr := q.Iter()
 
+	c.outErr = nil
+	stop := make(chan struct{})
+	c.stop = stop
+
 	// check for args
 	go func() {
-		defer itr.Close()
 		defer close(recordChan)
 
 		for {
@@ -166,110 +196,40 @@ func (c *Cassandra) Out(query string, args []string) (<-chan Record, error) {
 			if !itr.MapScan(row) {
 				break
 			}
-			recordChan <- row
-		}
-		if err := itr.Close(); err != nil {
-			log.Fatal(err)
+
+			select {
+			case recordChan <- row:
+			case <-stop:
+				itr.Close()
+				return
+			}
 		}
 
+		// query errors are returned when the iterator closes
+		c.outErr = itr.Close()
 	}()
 
 	return recordChan, nil
 }
 
-// ConfigSurvey is an implementation of Driver
-func (c *Cassandra) ConfigSurvey(config Config, machineName string) error {
-	fmt.Println("---- Cassandra Driver Configuration ----")
-
-	clusterList := ""
-	defClusterList := ""
-	if v, ok := config["clusterList"]; ok {
-		defClusterList = v.(string)
-	}
-	prompt := &survey.Input{
-		Message: "Nodes:",
-		Help:    "Comma separated list of nodes ex: \"n1.example.com,n2.example.com\"",
-		Default: defClusterList,
-	}
-	survey.AskOne(prompt, &clusterList, nil)
-	config["clusterList"] = clusterList
-
-	keySpace := ""
-	defKeySpace := ""
-	if v, ok := config["keyspace"]; ok {
-		defKeySpace = v.(string)
-	}
-	prompt = &survey.Input{
-		Message: "Keyspace:",
-		Help:    "The Cassandra keyspace to query against.",
-		Default: defKeySpace,
-	}
-	survey.AskOne(prompt, &keySpace, nil)
-	config["keyspace"] = keySpace
-
-	consistencyNames := make([]string, 0)
-	for _, v := range ConsistencyLookup {
-		consistencyNames = append(consistencyNames, v)
-	}
-
-	consistency := ""
-	defConsistency := ""
-	if v, ok := config["consistency"]; ok {
-		defConsistency = v.(string)
-	}
-	promptSelect := &survey.Select{
-		Message: "Choose a Consistency Level:",
-		Options: consistencyNames,
-		Default: defConsistency,
-	}
-	survey.AskOne(promptSelect, &consistency, nil)
-	config["consistency"] = consistency
+// OutErr for OutErrorer
+func (c *Cassandra) OutErr() error {
+	return c.outErr
+}
 
-	credentials := false
-	defCredentials := false
-	if _, ok := config["credentials"]; ok {
-		defCredentials = true
+// StopOut for OutStopper
+func (c *Cassandra) StopOut() {
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
 	}
-	promptBool := &survey.Confirm{
-		Message: "Does this cluster require login credentials?",
-		Default: defCredentials,
-	}
-	survey.AskOne(promptBool, &credentials, nil)
-
-	if credentials == true {
-		credentialConfig := Config{}
-		defCredentialConfig := make(map[interface{}]interface{})
-
-		if _, ok := config["credentials"]; ok {
-			defCredentialConfig = config["credentials"].(map[interface{}]interface{})
-		}
-
-		username := ""
-		defUsername := ""
-		if v, ok := defCredentialConfig["username"]; ok {
-			defUsername = v.(string)
-		}
-		prompt = &survey.Input{
-			Message: "Username:",
-			Default: defUsername,
-		}
-		survey.AskOne(prompt, &username, nil)
-		credentialConfig["username"] = username
+}
 
-		password := ""
-		defPassword := ""
-		if v, ok := defCredentialConfig["password"]; ok {
-			defPassword = v.(string)
-		}
-		prompt = &survey.Input{
-			Message: "Password:",
-			Default: defPassword,
-		}
-		survey.AskOne(prompt, &password, nil)
-		credentialConfig["password"] = password
+// ConfigSurvey is an implementation of Driver
+func (c *Cassandra) ConfigSurvey(config Config, machineName string) error {
+	fmt.Println("---- Cassandra Driver Configuration ----")
 
-		config["credentials"] = credentialConfig
-	}
+	c.ConfigSchema().Survey(config)
 
 	// populate
 	c.config = config
@@ -0,0 +1,378 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// collectionFlushSize is the number of items a boltCollection
+// buffers before writing them to disk.
+const collectionFlushSize = 1000
+
+// collectionPageSize is the number of items a boltCollection reads
+// per transaction when iterating.
+const collectionPageSize = 1000
+
+// CollectionStore holds the items sent to a Collector.
+type CollectionStore interface {
+	Append(item ResultCollectionItem) error                 // add an item
+	ForEach(fn func(item ResultCollectionItem) error) error // iterate items in insertion order
+	Len() int                                               // number of items
+	Clear() error                                           // remove all items
+	Flush() error                                           // write any buffered items
+	Lookup(key string) (ResultCollection, error)            // items with an index field value of key
+}
+
+// indexValue returns the value of the index field of a record as
+// a string, the second return value is false if there is no value.
+func indexValue(indexField string, record Record) (string, bool) {
+	if indexField == "" {
+		return "", false
+	}
+
+	v, ok := record[indexField]
+	if ok == false || v == nil {
+		return "", false
+	}
+
+	return fmt.Sprintf("%v", v), true
+}
+
+// memoryCollection is a CollectionStore kept in memory.
+type memoryCollection struct {
+	items      ResultCollection
+	indexField string
+	index      map[string][]int
+	mux        sync.RWMutex
+}
+
+// NewMemoryCollection returns an in memory CollectionStore. Items
+// are indexed by the value of indexField if it is not empty.
+func NewMemoryCollection(indexField string) CollectionStore {
+	return &memoryCollection{
+		indexField: indexField,
+		index:      make(map[string][]int),
+	}
+}
+
+// Append for CollectionStore interface.
+func (mc *memoryCollection) Append(item ResultCollectionItem) error {
+	mc.mux.Lock()
+	if k, ok := indexValue(mc.indexField, item.Record); ok {
+		mc.index[k] = append(mc.index[k], len(mc.items))
+	}
+	mc.items = append(mc.items, item)
+	mc.mux.Unlock()
+	return nil
+}
+
+// Lookup for CollectionStore interface.
+func (mc *memoryCollection) Lookup(key string) (ResultCollection, error) {
+	if mc.indexField == "" {
+		return nil, errors.New("collection has no indexField")
+	}
+
+	mc.mux.RLock()
+	defer mc.mux.RUnlock()
+
+	items := make(ResultCollection, 0, len(mc.index[key]))
+	for _, i := range mc.index[key] {
+		items = append(items, mc.items[i])
+	}
+
+	return items, nil
+}
+
+// ForEach for CollectionStore interface.
+func (mc *memoryCollection) ForEach(fn func(item ResultCollectionItem) error) error {
+	mc.mux.RLock()
+	items := mc.items
+	mc.mux.RUnlock()
+
+	for _, item := range items {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Len for CollectionStore interface.
+func (mc *memoryCollection) Len() int {
+	mc.mux.RLock()
+	defer mc.mux.RUnlock()
+	return len(mc.items)
+}
+
+// Clear for CollectionStore interface.
+func (mc *memoryCollection) Clear() error {
+	mc.mux.Lock()
+	mc.items = nil
+	mc.index = make(map[string][]int)
+	mc.mux.Unlock()
+	return nil
+}
+
+// Flush for CollectionStore interface.
+func (mc *memoryCollection) Flush() error {
+	return nil
+}
+
+// boltCollection is a CollectionStore kept in a bucket of a local
+// bolt database. Items are stored as JSON so record values come
+// back as JSON types (strings, float64 numbers, maps and slices).
+//
+// When indexed, a second bucket holds keys made of the index value
+// and the item sequence so items for a value are found with a
+// prefix scan.
+type boltCollection struct {
+	db          *bolt.DB
+	bucket      []byte
+	indexBucket []byte
+	indexField  string
+	buffer      ResultCollection
+	mux         sync.Mutex
+}
+
+// NewBoltCollection returns a CollectionStore using the named bucket
+// of a bolt database. Items are indexed by the value of indexField
+// if it is not empty.
+func NewBoltCollection(db *bolt.DB, bucket string, indexField string) (CollectionStore, error) {
+	bc := &boltCollection{
+		db:          db,
+		bucket:      []byte(bucket),
+		indexBucket: []byte(bucket + ".index"),
+		indexField:  indexField,
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bc.bucket)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(bc.indexBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bc, nil
+}
+
+// Append for CollectionStore interface. Items are buffered and
+// written in batches.
+func (bc *boltCollection) Append(item ResultCollectionItem) error {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	bc.buffer = append(bc.buffer, item)
+
+	if len(bc.buffer) >= collectionFlushSize {
+		return bc.flush()
+	}
+
+	return nil
+}
+
+// Flush for CollectionStore interface.
+func (bc *boltCollection) Flush() error {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.flush()
+}
+
+// flush writes buffered items, the caller must hold the lock
+func (bc *boltCollection) flush() error {
+	if len(bc.buffer) == 0 {
+		return nil
+	}
+
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bc.bucket)
+		ib := tx.Bucket(bc.indexBucket)
+
+		for _, item := range bc.buffer {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			v, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+
+			err = b.Put(collectionKey(seq), v)
+			if err != nil {
+				return err
+			}
+
+			if k, ok := indexValue(bc.indexField, item.Record); ok {
+				err = ib.Put(indexKey(k, seq), collectionKey(seq))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	bc.buffer = nil
+	return nil
+}
+
+// ForEach for CollectionStore interface. Items are read a page at
+// a time so no long running read transaction is held open.
+func (bc *boltCollection) ForEach(fn func(item ResultCollectionItem) error) error {
+	if err := bc.Flush(); err != nil {
+		return err
+	}
+
+	var next []byte
+
+	for {
+		page := make(ResultCollection, 0, collectionPageSize)
+
+		err := bc.db.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket(bc.bucket).Cursor()
+
+			k, v := c.First()
+			if next != nil {
+				k, v = c.Seek(next)
+			}
+
+			next = nil
+
+			for ; k != nil; k, v = c.Next() {
+				if len(page) == collectionPageSize {
+					next = append([]byte{}, k...)
+					break
+				}
+
+				item := ResultCollectionItem{}
+				if err := json.Unmarshal(v, &item); err != nil {
+					return err
+				}
+				page = append(page, item)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, item := range page {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+	}
+}
+
+// Len for CollectionStore interface.
+func (bc *boltCollection) Len() int {
+	bc.mux.Lock()
+	n := len(bc.buffer)
+	bc.mux.Unlock()
+
+	bc.db.View(func(tx *bolt.Tx) error {
+		n += tx.Bucket(bc.bucket).Stats().KeyN
+		return nil
+	})
+
+	return n
+}
+
+// Clear for CollectionStore interface.
+func (bc *boltCollection) Clear() error {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	bc.buffer = nil
+
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{bc.bucket, bc.indexBucket} {
+			err := tx.DeleteBucket(bucket)
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+
+			_, err = tx.CreateBucket(bucket)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Lookup for CollectionStore interface.
+func (bc *boltCollection) Lookup(key string) (ResultCollection, error) {
+	if bc.indexField == "" {
+		return nil, errors.New("collection has no indexField")
+	}
+
+	if err := bc.Flush(); err != nil {
+		return nil, err
+	}
+
+	items := make(ResultCollection, 0)
+	prefix := indexPrefix(key)
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bc.bucket)
+		c := tx.Bucket(bc.indexBucket).Cursor()
+
+		for k, seq := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, seq = c.Next() {
+			item := ResultCollectionItem{}
+			if err := json.Unmarshal(b.Get(seq), &item); err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+
+		return nil
+	})
+
+	return items, err
+}
+
+// indexKey encodes an index value and sequence as a key
+func indexKey(value string, seq uint64) []byte {
+	return append(indexPrefix(value), collectionKey(seq)...)
+}
+
+// indexPrefix returns the prefix of the index keys of a value. The
+// value is prefixed with its length so the keys of one value never
+// start with the prefix of another.
+func indexPrefix(value string) []byte {
+	k := make([]byte, 4, 4+len(value)+8)
+	binary.BigEndian.PutUint32(k, uint32(len(value)))
+	return append(k, value...)
+}
+
+// collectionKey encodes a sequence as a sortable key
+func collectionKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
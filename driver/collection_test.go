@@ -0,0 +1,234 @@
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// testBoltCollection returns an indexed boltCollection in a
+// temporary database
+func testBoltCollection(t *testing.T, indexField string) (*boltCollection, func()) {
+	dir, err := ioutil.TempDir("", "dmk-collection")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	cs, err := NewBoltCollection(db, "items", indexField)
+	if err != nil {
+		db.Close()
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return cs.(*boltCollection), func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// storedItems returns the number of items written to the bucket of
+// a boltCollection, not counting buffered items
+func storedItems(t *testing.T, bc *boltCollection) int {
+	n := 0
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(bc.bucket).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// TestCollectionLookup tests the index prefix scan of memory and
+// bolt collections only returns items with the exact index value,
+// including values containing zero bytes.
+func TestCollectionLookup(t *testing.T) {
+	bc, done := testBoltCollection(t, "name")
+	defer done()
+
+	stores := map[string]CollectionStore{
+		"memory": NewMemoryCollection("name"),
+		"bolt":   bc,
+	}
+
+	names := []interface{}{"a", "ab", "a", "b", "", 1, nil, "a b", "a\x00b", "a\x00"}
+
+	tests := []struct {
+		key  string
+		want []int // record ids in insertion order
+	}{
+		{"a", []int{0, 2}},
+		{"ab", []int{1}},
+		{"b", []int{3}},
+		{"", []int{4}},
+		{"1", []int{5}},
+		{"a b", []int{7}},
+		{"a\x00b", []int{8}},
+		{"a\x00", []int{9}},
+		{"a\x00\x00", []int{}},
+		{"missing", []int{}},
+	}
+
+	for storeName, cs := range stores {
+		for i, name := range names {
+			err := cs.Append(ResultCollectionItem{Record: Record{"id": strconv.Itoa(i), "name": name}})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, tt := range tests {
+			items, err := cs.Lookup(tt.key)
+			if err != nil {
+				t.Fatalf("%s %q: %s", storeName, tt.key, err)
+			}
+
+			got := make([]int, 0, len(items))
+			for _, item := range items {
+				id, _ := strconv.Atoi(item.Record["id"].(string))
+				got = append(got, id)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Errorf("%s %q: got ids %v, want %v", storeName, tt.key, got, tt.want)
+				continue
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("%s %q: got ids %v, want %v", storeName, tt.key, got, tt.want)
+					break
+				}
+			}
+		}
+	}
+}
+
+// TestCollectionLookupNoIndex tests a collection without an index
+// field can not be looked up.
+func TestCollectionLookupNoIndex(t *testing.T) {
+	bc, done := testBoltCollection(t, "")
+	defer done()
+
+	for storeName, cs := range map[string]CollectionStore{"memory": NewMemoryCollection(""), "bolt": bc} {
+		if _, err := cs.Lookup("a"); err == nil {
+			t.Errorf("%s: expected an error", storeName)
+		}
+	}
+}
+
+// TestBoltCollectionFlush tests items are buffered until the flush
+// size is reached or the collection is read or flushed.
+func TestBoltCollectionFlush(t *testing.T) {
+	tests := []struct {
+		name       string
+		appends    int
+		read       func(bc *boltCollection) error
+		wantStored int
+	}{
+		{"buffered", 10, nil, 0},
+		{"flush size", collectionFlushSize + 10, nil, collectionFlushSize},
+		{"flush", 10, func(bc *boltCollection) error { return bc.Flush() }, 10},
+		{"for each", 10, func(bc *boltCollection) error {
+			return bc.ForEach(func(item ResultCollectionItem) error { return nil })
+		}, 10},
+		{"lookup", 10, func(bc *boltCollection) error {
+			_, err := bc.Lookup("x")
+			return err
+		}, 10},
+	}
+
+	for _, tt := range tests {
+		bc, done := testBoltCollection(t, "name")
+
+		for i := 0; i < tt.appends; i++ {
+			err := bc.Append(ResultCollectionItem{Record: Record{"name": "x"}})
+			if err != nil {
+				t.Fatalf("%s: %s", tt.name, err)
+			}
+		}
+
+		if tt.read != nil {
+			if err := tt.read(bc); err != nil {
+				t.Fatalf("%s: %s", tt.name, err)
+			}
+		}
+
+		if got := storedItems(t, bc); got != tt.wantStored {
+			t.Errorf("%s: got %d stored items, want %d", tt.name, got, tt.wantStored)
+		}
+
+		if got := bc.Len(); got != tt.appends {
+			t.Errorf("%s: Len is %d, want %d", tt.name, got, tt.appends)
+		}
+
+		done()
+	}
+}
+
+// TestBoltCollectionForEach tests items are read in insertion order
+// across pages and after a Clear.
+func TestBoltCollectionForEach(t *testing.T) {
+	bc, done := testBoltCollection(t, "")
+	defer done()
+
+	tests := []struct {
+		name  string
+		count int
+		clear bool
+	}{
+		{"empty", 0, false},
+		{"one page", 3, false},
+		{"several pages", collectionPageSize*2 + 5, false},
+		{"after clear", 7, true},
+	}
+
+	for _, tt := range tests {
+		if tt.clear {
+			if err := bc.Clear(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// items appended by earlier cases are read first
+		offset := bc.Len()
+		if tt.clear {
+			offset = 0
+		}
+
+		for i := 0; i < tt.count; i++ {
+			err := bc.Append(ResultCollectionItem{Record: Record{"n": strconv.Itoa(offset + i)}})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		n := 0
+		err := bc.ForEach(func(item ResultCollectionItem) error {
+			if item.Record["n"] != strconv.Itoa(n) {
+				t.Fatalf("%s: item %d is %v", tt.name, n, item.Record["n"])
+			}
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		if n != offset+tt.count {
+			t.Errorf("%s: read %d items, want %d", tt.name, n, offset+tt.count)
+		}
+	}
+}
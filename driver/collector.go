@@ -3,6 +3,9 @@ package driver
 import (
 	"errors"
 	"fmt"
+	"sync"
+
+	"github.com/boltdb/bolt"
 )
 
 // ResultCollectionItem represents a set of records and corresponding args.
@@ -14,15 +17,41 @@ type ResultCollectionItem struct {
 // ResultCollection represents a slice of ResultCollectionItem
 type ResultCollection []ResultCollectionItem
 
-// CollectorStore holds a map of ResultCollection
-var CollectorStore = map[string]ResultCollection{}
+// collectorEntry is a shared collection and the configuration it was
+// created with
+type collectorEntry struct {
+	collection CollectionStore
+	storage    string
+	indexField string
+}
+
+// CollectorStore holds a map of collection keys to collections. A
+// collection is shared by every Collector using the same key and
+// configuration until ReleaseCollections is called.
+var CollectorStore = map[string]collectorEntry{}
+
+// collectorStoreMux guards CollectorStore
+var collectorStoreMux sync.Mutex
+
+// LocalDbUser is implemented by drivers that keep data in a local
+// database. The runner sets a function that opens (or returns an
+// already open) local database by name before Configure is called.
+type LocalDbUser interface {
+	SetLocalDb(open func(name string) (*bolt.DB, error))
+}
 
 // Collector implements data.Driver
-// TODO: implement a generate persistent kv object store
+//
+// Items are kept in memory or, with the "disk" storage option, in
+// a local bolt database named after the collection key. A run that
+// sends items to a Collector replaces the items of any previous run.
+// With an indexField configured, items are grouped by the value of
+// that record field and can be retrieved with Lookup.
 type Collector struct {
 	config        Config
 	collectionKey string
-	store         ResultCollection
+	collection    CollectionStore
+	openLocalDb   func(name string) (*bolt.DB, error)
 	init          bool
 }
 
@@ -34,12 +63,27 @@ func (c *Collector) ArgCount(query string) int {
 
 // Init initializes at the beginning of each run.
 func (c *Collector) Init() {
-	c.store = nil
+	c.init = false
 }
 
-// GetCollection returns slice of ResultCollectionItem
+// GetCollection returns slice of ResultCollectionItem collected
+// in the current run.
 func (c *Collector) GetCollection() []ResultCollectionItem {
-	return c.store
+	collection := make([]ResultCollectionItem, 0)
+
+	if c.init == false {
+		return collection
+	}
+
+	err := c.collection.ForEach(func(item ResultCollectionItem) error {
+		collection = append(collection, item)
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("ERROR: reading collection %s: %s\n", c.collectionKey, err.Error())
+	}
+
+	return collection
 }
 
 // HasOutQuery is false for Collector
@@ -57,45 +101,143 @@ func (c *Collector) HasCountQuery() bool {
 	return false
 }
 
-// Configure (keys determined in ConfigSurvey)
+// SetLocalDb for the LocalDbUser interface.
+func (c *Collector) SetLocalDb(open func(name string) (*bolt.DB, error)) {
+	c.openLocalDb = open
+}
+
+// ConfigSchema for Driver interface.
+func (c *Collector) ConfigSchema() ConfigSchema {
+	return ConfigSchema{
+		{Key: "collectionKey", Type: ConfigString, Required: true, Hidden: true, Help: "Name of the collection, the database machine name."},
+		{Key: "storage", Type: ConfigString, Label: "Collector Storage:", Default: "memory", Options: []string{"memory", "disk"}, Help: "Keep collected records in memory or on disk in a local database."},
+		{Key: "indexField", Type: ConfigString, Label: "Index Field (optional):", Help: "Record field to group and look up collected records by. Ex: `name`"},
+	}
+}
+
+// Configure (keys determined in ConfigSchema)
 func (c *Collector) Configure(config Config) error {
+	config, err := c.ConfigSchema().Prepare(config)
+	if err != nil {
+		return err
+	}
+
 	c.config = config
+	c.collectionKey = config["collectionKey"].(string)
+	storage := config["storage"].(string)
+
+	indexField, _ := config["indexField"].(string)
+
+	collectorStoreMux.Lock()
+	defer collectorStoreMux.Unlock()
+
+	// re-use the collection of another Collector with the same key,
+	// a changed configuration replaces it
+	if entry, ok := CollectorStore[c.collectionKey]; ok {
+		if entry.storage == storage && entry.indexField == indexField {
+			c.collection = entry.collection
+			return nil
+		}
+
+		if entry.storage == "memory" {
+			entry.collection.Clear()
+		}
+		delete(CollectorStore, c.collectionKey)
+	}
+
+	switch storage {
+	case "memory":
+		c.collection = NewMemoryCollection(indexField)
+	case "disk":
+		if c.openLocalDb == nil {
+			return errors.New("disk storage for collector " + c.collectionKey + " requires a local database")
+		}
 
-	//fmt.Println("Configuring collector.")
+		db, err := c.openLocalDb("collector_" + c.collectionKey)
+		if err != nil {
+			return err
+		}
 
-	if _, ok := config["collectionKey"]; ok == false {
-		fmt.Println("ERROR: no collectionKey")
-		return errors.New("collectionKey does not exist in configuration")
+		c.collection, err = NewBoltCollection(db, c.collectionKey, indexField)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("unknown collector storage " + storage + ", use memory or disk")
 	}
 
-	c.collectionKey = c.config["collectionKey"].(string)
+	CollectorStore[c.collectionKey] = collectorEntry{
+		collection: c.collection,
+		storage:    storage,
+		indexField: indexField,
+	}
 
 	return nil
 }
 
+// ReleaseCollections drops the shared collections at the end of a
+// run, emptying those kept in memory. Disk collections keep their
+// items, their local databases are closed by the caller.
+func ReleaseCollections() error {
+	collectorStoreMux.Lock()
+	defer collectorStoreMux.Unlock()
+
+	var err error
+
+	for key, entry := range CollectorStore {
+		if entry.storage == "memory" {
+			if cerr := entry.collection.Clear(); cerr != nil {
+				err = cerr
+			}
+		}
+		delete(CollectorStore, key)
+	}
+
+	return err
+}
+
 // Done for Driver interface.
 func (c *Collector) Done() error {
-	return nil
+	// a run without records leaves an empty collection
+	if c.init == false {
+		return c.collection.Clear()
+	}
+
+	return c.collection.Flush()
 }
 
 // In for Driver interface.
 func (c *Collector) In(query string, args []string, record Record) error {
-	//fmt.Println("Got collector in.")
 	// in the future query can be used to specify a different storage key and type
 	rci := ResultCollectionItem{
 		Record: record,
 		Args:   args,
 	}
 
-	CollectorStore[c.collectionKey] = append(CollectorStore[c.collectionKey], rci)
-	c.store = append(c.store, rci)
+	// the first record of a run replaces the previous collection
+	if c.init == false {
+		if err := c.collection.Clear(); err != nil {
+			return err
+		}
+		c.init = true
+	}
 
-	return nil
+	return c.collection.Append(rci)
+}
+
+// Lookup returns the items with an indexField value of key.
+func (c *Collector) Lookup(key string) ([]ResultCollectionItem, error) {
+	items, err := c.collection.Lookup(key)
+	if err != nil {
+		return nil, errors.New(c.collectionKey + ": " + err.Error())
+	}
+
+	return items, nil
 }
 
 // ExpectedOut returns true and the number of expected outbound records,
 func (c *Collector) ExpectedOut() (bool, int, error) {
-	return true, len(CollectorStore[c.collectionKey]), nil
+	return true, c.collection.Len(), nil
 }
 
 // Out for Driver interface.
@@ -104,12 +246,15 @@ func (c *Collector) Out(query string, args []string) (<-chan Record, error) {
 	recordChan := make(chan Record, 1)
 
 	go func() {
-		if collection, ok := CollectorStore[c.collectionKey]; ok {
-			for _, collectionItem := range collection {
-				recordChan <- collectionItem.Record
-			}
+		defer close(recordChan)
+
+		err := c.collection.ForEach(func(item ResultCollectionItem) error {
+			recordChan <- item.Record
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("ERROR: reading collection %s: %s\n", c.collectionKey, err.Error())
 		}
-		close(recordChan)
 	}()
 
 	return recordChan, nil
@@ -118,6 +263,9 @@ func (c *Collector) Out(query string, args []string) (<-chan Record, error) {
 // ConfigSurvey is an implementation of Driver
 func (c *Collector) ConfigSurvey(config Config, machineName string) error {
 	config["collectionKey"] = machineName
+
+	c.ConfigSchema().Survey(config)
+
 	return nil
 }
 
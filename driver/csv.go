@@ -7,7 +7,6 @@ import (
 	"log"
 	"os"
 
-	"github.com/AlecAivazis/survey"
 	"github.com/recursionpharma/go-csv-map"
 )
 
@@ -43,13 +42,18 @@ func (c *CSV) HasCountQuery() bool {
 	return false
 }
 
-// Configure (keys determined in ConfigSurvey)
-func (c *CSV) Configure(config Config) error {
+// ConfigSchema for Driver interface.
+func (c *CSV) ConfigSchema() ConfigSchema {
+	return ConfigSchema{
+		{Key: "filePath", Type: ConfigString, Label: "File:", Required: true, Help: "Path to CSV file: \"./somedir/somefile.csv\""},
+	}
+}
 
-	// Validation
-	_, ok := config["filePath"]
-	if ok != true {
-		return errors.New("missing config key filePath")
+// Configure (keys determined in ConfigSchema)
+func (c *CSV) Configure(config Config) error {
+	config, err := c.ConfigSchema().Prepare(config)
+	if err != nil {
+		return err
 	}
 
 	c.config = config
@@ -57,6 +61,34 @@ func (c *CSV) Configure(config Config) error {
 	return nil
 }
 
+// Ping for Pinger interface, checks that the file exists and has a
+// header.
+func (c *CSV) Ping() error {
+	if c.config == nil {
+		return errors.New("CSV is not configured")
+	}
+
+	csvIn, err := os.Open(c.config["filePath"].(string))
+	if err != nil {
+		return err
+	}
+	defer csvIn.Close()
+
+	header, err := csvmap.NewReader(csvIn).ReadHeader()
+	if err == io.EOF {
+		return errors.New("file " + csvIn.Name() + " is empty, expecting a header")
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(header) == 0 {
+		return errors.New("file " + csvIn.Name() + " has no header")
+	}
+
+	return nil
+}
+
 // Done for Driver interface.
 func (c *CSV) Done() error {
 	return nil
@@ -134,13 +166,7 @@ func (c *CSV) Out(query string, args []string) (<-chan Record, error) {
 func (c *CSV) ConfigSurvey(config Config, machineName string) error {
 	fmt.Println("---- CSV Driver Configuration ----")
 
-	filePath := ""
-	prompt := &survey.Input{
-		Message: "File:",
-		Help:    "Path to CSV file: \"./somedir/somefile.csv\"",
-	}
-	survey.AskOne(prompt, &filePath, nil)
-	config["filePath"] = filePath
+	c.ConfigSchema().Survey(config)
 
 	return nil
 }
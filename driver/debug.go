@@ -35,6 +35,11 @@ func (d *Debug) HasCountQuery() bool {
 	return false
 }
 
+// ConfigSchema for Driver interface, Debug takes no configuration.
+func (d *Debug) ConfigSchema() ConfigSchema {
+	return ConfigSchema{}
+}
+
 // Configure (keys determined in ConfigSurvey)
 func (d *Debug) Configure(config Config) error {
 	d.config = config
@@ -43,6 +43,7 @@ type DataMap interface {
 // Driver managed configuration and of a database and executes queries against it.
 type Driver interface {
 	Configure(config Config) error                          // Takes a config map
+	ConfigSchema() ConfigSchema                             // Describes the config map
 	ConfigSurvey(config Config, machineName string) error   // Interactive config generator
 	Init()                                                  // Initialization tasks (as drivers may be reused)
 	Out(query string, args []string) (<-chan Record, error) // outbound data
@@ -58,6 +59,49 @@ type Driver interface {
 	HasCountQuery() bool             // does this driver have a count query
 }
 
+// Pinger is implemented by drivers that can check a configured
+// connection, ex: a query any database can answer.
+type Pinger interface {
+	Ping() error
+}
+
+// OutErrorer is implemented by drivers that can fail while sending the
+// records of Out. OutErr returns the error of the last Out once its
+// record channel is closed.
+type OutErrorer interface {
+	OutErr() error
+}
+
+// OutStopper is implemented by drivers that can stop sending the
+// records of the last Out before all are read.
+type OutStopper interface {
+	StopOut()
+}
+
+// OutError returns the error of the last Out of a driver, call it once
+// the record channel is closed.
+func OutError(d Driver) error {
+	if oe, ok := d.(OutErrorer); ok {
+		return oe.OutErr()
+	}
+
+	return nil
+}
+
+// StopOut releases the records of an Out that are not read. Drivers
+// that can stop are stopped, the remaining records of others are read
+// and dropped so the driver is not blocked sending them.
+func StopOut(d Driver, records <-chan Record) {
+	if stopper, ok := d.(OutStopper); ok {
+		stopper.StopOut()
+	}
+
+	go func() {
+		for range records {
+		}
+	}()
+}
+
 // Manager handles the collection of drivers
 type Manager struct {
 	// a map of of machine names to drivers
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/AlecAivazis/survey"
 	_ "github.com/go-sql-driver/mysql" // driver import
 )
 
@@ -14,6 +13,8 @@ import (
 type MySql struct {
 	config Config
 	db     *sql.DB
+	outErr error         // error of the last Out
+	stop   chan struct{} // closed to stop the last Out
 }
 
 // Init initializes at the beginning of each run.
@@ -42,50 +43,42 @@ func (m *MySql) HasCountQuery() bool {
 	return true
 }
 
-// Configure (keys determined in ConfigSurvey)
-func (m *MySql) Configure(config Config) error {
-	fmt.Printf("Configuring a MySQL driver.\n")
-
-	// Validation
-	dbName, ok := config["databaseName"].(string)
-	if ok != true {
-		return errors.New("missing config key databaseName")
-	}
-
-	host, ok := config["databaseHost"].(string)
-	if ok != true {
-		return errors.New("missing config key databaseHost")
+// ConfigSchema for Driver interface.
+func (m *MySql) ConfigSchema() ConfigSchema {
+	return ConfigSchema{
+		{Key: "databaseHost", Type: ConfigString, Label: "Database Host:", Required: true, Help: "The host of the MySql database."},
+		{Key: "databasePort", Type: ConfigString, Label: "Database Port:", Required: true, Default: "3306", Help: "The port of the MySql database."},
+		{Key: "username", Type: ConfigString, Label: "Username:", Required: true, Help: "The MySql database username."},
+		{Key: "credentials", Type: ConfigMap, Label: "Does this MySql database require login password?", Help: "Login credentials.", Keys: ConfigSchema{
+			{Key: "password", Type: ConfigString, Label: "Password:", Required: true, Secret: true, Help: "The MySql database password."},
+		}},
+		{Key: "databaseName", Type: ConfigString, Label: "Database Name:", Required: true, Help: "The MySql database name to query against."},
 	}
+}
 
-	port, ok := config["databasePort"].(string)
-	if ok != true {
-		return errors.New("missing config key databasePort")
-	}
+// Configure (keys determined in ConfigSchema)
+func (m *MySql) Configure(config Config) error {
+	fmt.Printf("Configuring a MySQL driver.\n")
 
-	username, ok := config["username"].(string)
-	if ok != true {
-		return errors.New("missing credential key username")
+	config, err := m.ConfigSchema().Prepare(config)
+	if err != nil {
+		return err
 	}
 
 	password := ""
-
-	if credentialsInt, ok := config["credentials"].(map[interface{}]interface{}); ok {
-		if ok != true {
-			return errors.New("configured value of MySql credentials is not an interface")
-		}
-		// create u/p slice
-		up := make(map[string]string)
-		for i, v := range credentialsInt {
-			up[i.(string)] = v.(string)
-		}
-		password, ok = up["password"]
-		if ok != true {
-			return errors.New("missing credential key password")
-		}
+	if credentials, ok := config["credentials"].(Config); ok {
+		password = credentials["password"].(string)
 	}
 
+	username := config["username"].(string)
+	host := config["databaseHost"].(string)
+	port := config["databasePort"].(string)
+	dbName := config["databaseName"].(string)
+
 	connectionStr := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", username, password, host, port, dbName)
-	fmt.Printf("MySql driver connecting to: %s\n", connectionStr)
+
+	// the password is not printed
+	fmt.Printf("MySql driver connecting to: %s@tcp(%s:%s)/%s\n", username, host, port, dbName)
 
 	database, err := sql.Open("mysql", connectionStr)
 	if err != nil {
@@ -105,6 +98,15 @@ func (m *MySql) Configure(config Config) error {
 	return nil
 }
 
+// Ping for Pinger interface.
+func (m *MySql) Ping() error {
+	if m.db == nil {
+		return errors.New("MySql is not configured")
+	}
+
+	return m.db.Ping()
+}
+
 // Done for Driver interface.
 func (m *MySql) Done() error {
 	return nil
@@ -149,94 +151,70 @@ func (m *MySql) Out(query string, args []string) (<-chan Record, error) {
 		return nil, err
 	}
 
+	m.outErr = nil
+	stop := make(chan struct{})
+	m.stop = stop
+
 	go func() {
+		defer close(recordChan)
 		defer rows.Close()
+
 		for rows.Next() {
 
-			colsRef := make([]string, len(cols))
+			colsRef := make([]sql.NullString, len(cols))
 			columnPointers := make([]interface{}, len(cols))
 			for i := range cols {
 				columnPointers[i] = &colsRef[i]
 			}
 
-			err = rows.Scan(columnPointers...)
+			err := rows.Scan(columnPointers...)
 			if err != nil {
-				panic(err.Error())
+				m.outErr = err
+				return
 			}
 
+			// NULL columns are nil
 			record := Record{}
 			for i, col := range cols {
-				cp := columnPointers[i]
-				record[col] = *cp.(*string)
+				if colsRef[i].Valid {
+					record[col] = colsRef[i].String
+				} else {
+					record[col] = nil
+				}
 			}
 
-			recordChan <- record
+			select {
+			case recordChan <- record:
+			case <-stop:
+				return
+			}
 		}
 
 		// fell out of loop
-		close(recordChan)
-
+		m.outErr = rows.Err()
 	}()
 
 	return recordChan, nil
 }
 
-// ConfigSurvey is an implementation of Driver
-func (m *MySql) ConfigSurvey(config Config, machineName string) error {
-	fmt.Println("---- MySql Driver Configuration ----")
-
-	host := ""
-	prompt := &survey.Input{
-		Message: "Database Host:",
-		Help:    "The host of the MySql database.",
-	}
-	survey.AskOne(prompt, &host, nil)
-	config["databaseHost"] = host
-
-	port := ""
-	prompt = &survey.Input{
-		Message: "Database Port:",
-		Default: "3306",
-		Help:    "The port of the MySql database.",
-	}
-	survey.AskOne(prompt, &port, nil)
-	config["databasePort"] = port
-
-	username := ""
-	prompt = &survey.Input{
-		Message: "Username:",
-		Help:    "The MySql database username.",
-	}
-	survey.AskOne(prompt, &username, nil)
-	config["username"] = username
+// OutErr for OutErrorer
+func (m *MySql) OutErr() error {
+	return m.outErr
+}
 
-	credentials := false
-	promptBool := &survey.Confirm{
-		Message: "Does this MySql database require login password?",
+// StopOut for OutStopper
+func (m *MySql) StopOut() {
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
 	}
-	survey.AskOne(promptBool, &credentials, nil)
-
-	if credentials == true {
-		credentialConfig := Config{}
-
-		password := ""
-		prompt = &survey.Input{
-			Message: "Password:",
-			Help:    "The MySql database password.",
-		}
-		survey.AskOne(prompt, &password, nil)
-		credentialConfig["password"] = password
+}
 
-		config["credentials"] = credentialConfig
-	}
+// ConfigSurvey is an implementation of Driver
+func (m *MySql) ConfigSurvey(config Config, machineName string) error {
+	fmt.Println("---- MySql Driver Configuration ----")
 
-	dbName := ""
-	prompt = &survey.Input{
-		Message: "Database Name:",
-		Help:    "The MySql database name to query against.",
-	}
-	survey.AskOne(prompt, &dbName, nil)
-	config["databaseName"] = dbName
+	m.ConfigSchema().Survey(config)
 
 	return nil
 }
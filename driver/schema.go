@@ -0,0 +1,346 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/AlecAivazis/survey"
+)
+
+// Configuration value types
+const (
+	ConfigString = "string"
+	ConfigList   = "list"
+	ConfigMap    = "map"
+)
+
+// ConfigKey describes a driver configuration key
+type ConfigKey struct {
+	Key      string       // key in the configuration map
+	Type     string       // ConfigString, ConfigList or ConfigMap
+	Label    string       // survey prompt, the confirmation for optional maps
+	Required bool         // the key must be set
+	Default  string       // value used when the key is not set
+	Secret   bool         // prompt without echo
+	Hidden   bool         // set by the driver survey, never prompted for
+	Options  []string     // allowed values of a string
+	Help     string       // description
+	Keys     ConfigSchema // keys of a map
+}
+
+// ConfigSchema describes the configuration of a driver. Validation,
+// defaults and the configuration survey are derived from it.
+type ConfigSchema []ConfigKey
+
+// Validate returns errors for missing required keys, unknown keys
+// and values of the wrong type.
+func (s ConfigSchema) Validate(config Config) []error {
+	return s.validate(config, "")
+}
+
+// validate checks a configuration, prefix is prepended to keys of
+// nested maps.
+func (s ConfigSchema) validate(config Config, prefix string) []error {
+	errs := make([]error, 0)
+	known := make(map[string]bool, len(s))
+
+	for _, ck := range s {
+		known[ck.Key] = true
+		key := prefix + ck.Key
+
+		v, ok := config[ck.Key]
+		if ok == false {
+			if ck.Required && ck.Default == "" {
+				errs = append(errs, errors.New("missing config key "+key))
+			}
+			continue
+		}
+
+		switch ck.Type {
+		case ConfigList:
+			if _, ok := configList(v); ok == false {
+				errs = append(errs, errors.New("config key "+key+" must be a list"))
+			}
+		case ConfigMap:
+			m, ok := configMap(v)
+			if ok == false {
+				errs = append(errs, errors.New("config key "+key+" must be a map"))
+				continue
+			}
+			errs = append(errs, ck.Keys.validate(m, key+".")...)
+		default:
+			sv, ok := v.(string)
+			if ok == false {
+				errs = append(errs, errors.New("config key "+key+" must be a string"))
+				continue
+			}
+			if len(ck.Options) > 0 && ck.option(sv) == false {
+				errs = append(errs, errors.New("config key "+key+" must be one of "+strings.Join(ck.Options, ", ")))
+			}
+		}
+	}
+
+	unknown := make([]string, 0)
+	for k := range config {
+		if known[k] == false {
+			unknown = append(unknown, k)
+		}
+	}
+
+	sort.Strings(unknown)
+
+	for _, k := range unknown {
+		errs = append(errs, errors.New("unknown config key "+prefix+k))
+	}
+
+	return errs
+}
+
+// Prepare validates a configuration and returns a copy with defaults
+// set for missing keys and nested maps converted to Config.
+func (s ConfigSchema) Prepare(config Config) (Config, error) {
+	errs := s.Validate(config)
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, err := range errs {
+			msgs[i] = err.Error()
+		}
+		return nil, errors.New(strings.Join(msgs, "; "))
+	}
+
+	return s.prepare(config), nil
+}
+
+// prepare copies a valid configuration, setting defaults
+func (s ConfigSchema) prepare(config Config) Config {
+	prepared := make(Config, len(config))
+
+	for _, ck := range s {
+		v, ok := config[ck.Key]
+		if ok == false {
+			if ck.Default != "" {
+				prepared[ck.Key] = ck.Default
+			}
+			continue
+		}
+
+		switch ck.Type {
+		case ConfigList:
+			l, _ := configList(v)
+			prepared[ck.Key] = l
+		case ConfigMap:
+			m, _ := configMap(v)
+			prepared[ck.Key] = ck.Keys.prepare(m)
+		default:
+			prepared[ck.Key] = v
+		}
+	}
+
+	return prepared
+}
+
+// Set sets a configuration value from a string. Keys of nested
+// maps are dot separated, ex: credentials.password. List values are
+// comma separated.
+func (s ConfigSchema) Set(config Config, key string, value string) error {
+	parts := strings.SplitN(key, ".", 2)
+
+	for _, ck := range s {
+		if ck.Key != parts[0] {
+			continue
+		}
+
+		if ck.Type != ConfigMap {
+			if len(parts) > 1 {
+				return errors.New("config key " + parts[0] + " is not a map")
+			}
+			if ck.Type == ConfigList {
+				list := make([]string, 0)
+				for _, item := range strings.Split(value, ",") {
+					if item = strings.TrimSpace(item); item != "" {
+						list = append(list, item)
+					}
+				}
+				config[ck.Key] = list
+				return nil
+			}
+			config[ck.Key] = value
+			return nil
+		}
+
+		if len(parts) == 1 {
+			return errors.New("config key " + key + " is a map, set its keys with " + key + ".KEY")
+		}
+
+		m, ok := configMap(config[ck.Key])
+		if ok == false {
+			m = Config{}
+		}
+		if err := ck.Keys.Set(m, parts[1], value); err != nil {
+			return errors.New(strings.Replace(err.Error(), "config key ", "config key "+ck.Key+".", 1))
+		}
+		config[ck.Key] = m
+		return nil
+	}
+
+	return errors.New("unknown config key " + key)
+}
+
+// Survey prompts for each key of the schema, using the values in
+// config as defaults, and sets the answers in config.
+func (s ConfigSchema) Survey(config Config) {
+	for _, ck := range s {
+		if ck.Hidden {
+			continue
+		}
+
+		switch ck.Type {
+		case ConfigList:
+			current, _ := configList(config[ck.Key])
+			answer := ""
+			prompt := &survey.Input{
+				Message: ck.Label,
+				Help:    ck.Help,
+				Default: strings.Join(current, ", "),
+			}
+			survey.AskOne(prompt, &answer, nil)
+
+			list := make([]string, 0)
+			for _, item := range strings.Split(answer, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			config[ck.Key] = list
+		case ConfigMap:
+			current, ok := configMap(config[ck.Key])
+			if ok == false {
+				current = Config{}
+			}
+
+			if ck.Required == false {
+				set := false
+				promptBool := &survey.Confirm{
+					Message: ck.Label,
+					Help:    ck.Help,
+					Default: ok,
+				}
+				survey.AskOne(promptBool, &set, nil)
+
+				if set == false {
+					delete(config, ck.Key)
+					continue
+				}
+			}
+
+			ck.Keys.Survey(current)
+			config[ck.Key] = current
+		default:
+			config[ck.Key] = ck.ask(config)
+			if config[ck.Key] == "" && ck.Required == false {
+				delete(config, ck.Key)
+			}
+		}
+	}
+}
+
+// ask prompts for a string value
+func (ck ConfigKey) ask(config Config) string {
+	current, ok := config[ck.Key].(string)
+	if ok == false {
+		current = ck.Default
+	}
+
+	answer := ""
+
+	if ck.Secret {
+		help := ck.Help
+		if current != "" {
+			help = strings.TrimSpace(help + " Leave empty to keep the current value.")
+		}
+		prompt := &survey.Password{
+			Message: ck.Label,
+			Help:    help,
+		}
+		survey.AskOne(prompt, &answer, nil)
+
+		if answer == "" {
+			return current
+		}
+		return answer
+	}
+
+	if len(ck.Options) > 0 {
+		if ck.option(current) == false {
+			current = ""
+		}
+		prompt := &survey.Select{
+			Message: ck.Label,
+			Help:    ck.Help,
+			Options: ck.Options,
+			Default: current,
+		}
+		survey.AskOne(prompt, &answer, nil)
+		return answer
+	}
+
+	prompt := &survey.Input{
+		Message: ck.Label,
+		Help:    ck.Help,
+		Default: current,
+	}
+	survey.AskOne(prompt, &answer, nil)
+
+	return answer
+}
+
+// option returns true if v is one of the allowed values
+func (ck ConfigKey) option(v string) bool {
+	for _, o := range ck.Options {
+		if o == v {
+			return true
+		}
+	}
+	return false
+}
+
+// configList converts a list value to a string slice
+func configList(v interface{}) ([]string, bool) {
+	switch l := v.(type) {
+	case []string:
+		return l, true
+	case []interface{}:
+		list := make([]string, 0, len(l))
+		for _, item := range l {
+			s, ok := item.(string)
+			if ok == false {
+				return nil, false
+			}
+			list = append(list, s)
+		}
+		return list, true
+	}
+
+	return nil, false
+}
+
+// configMap converts a map value, as loaded from yaml or set by a
+// survey, to a Config.
+func configMap(v interface{}) (Config, bool) {
+	switch m := v.(type) {
+	case Config:
+		return m, true
+	case map[string]interface{}:
+		return Config(m), true
+	case map[interface{}]interface{}:
+		c := make(Config, len(m))
+		for k, mv := range m {
+			c[fmt.Sprintf("%v", k)] = mv
+		}
+		return c, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,99 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+)
+
+// testSchema is a schema with a key of each type
+var testSchema = ConfigSchema{
+	{Key: "host", Type: ConfigString, Required: true},
+	{Key: "port", Type: ConfigString, Required: true, Default: "9042"},
+	{Key: "mode", Type: ConfigString, Options: []string{"a", "b"}},
+	{Key: "hosts", Type: ConfigList},
+	{Key: "credentials", Type: ConfigMap, Keys: ConfigSchema{
+		{Key: "username", Type: ConfigString, Required: true},
+		{Key: "password", Type: ConfigString},
+	}},
+}
+
+// TestConfigSchemaValidate tests missing, unknown and wrongly typed
+// keys are reported, including keys of nested maps.
+func TestConfigSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   []string
+	}{
+		{
+			name:   "valid",
+			config: Config{"host": "localhost"},
+			want:   []string{},
+		},
+		{
+			name: "all keys",
+			config: Config{
+				"host":        "localhost",
+				"port":        "1",
+				"mode":        "b",
+				"hosts":       []interface{}{"a", "b"},
+				"credentials": map[interface{}]interface{}{"username": "u", "password": "p"},
+			},
+			want: []string{},
+		},
+		{
+			name:   "missing",
+			config: Config{},
+			want:   []string{"missing config key host"},
+		},
+		{
+			name:   "unknown",
+			config: Config{"host": "localhost", "z": "1", "b": "2"},
+			want:   []string{"unknown config key b", "unknown config key z"},
+		},
+		{
+			name:   "wrong types",
+			config: Config{"host": 1, "hosts": "a", "credentials": "u"},
+			want: []string{
+				"config key host must be a string",
+				"config key hosts must be a list",
+				"config key credentials must be a map",
+			},
+		},
+		{
+			name:   "list item type",
+			config: Config{"host": "localhost", "hosts": []interface{}{"a", 1}},
+			want:   []string{"config key hosts must be a list"},
+		},
+		{
+			name:   "option",
+			config: Config{"host": "localhost", "mode": "c"},
+			want:   []string{"config key mode must be one of a, b"},
+		},
+		{
+			name: "nested map",
+			config: Config{
+				"host":        "localhost",
+				"credentials": map[string]interface{}{"password": 1, "token": "t"},
+			},
+			want: []string{
+				"missing config key credentials.username",
+				"config key credentials.password must be a string",
+				"unknown config key credentials.token",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		errs := testSchema.Validate(tt.config)
+
+		got := make([]string, len(errs))
+		for i, err := range errs {
+			got[i] = err.Error()
+		}
+
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
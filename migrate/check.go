@@ -0,0 +1,85 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/txn2/dmk/driver"
+)
+
+// Database check stages
+const (
+	CheckTunnel    = "tunnel"
+	CheckConfigure = "configure"
+	CheckPing      = "ping"
+)
+
+// DatabaseCheck is the result of checking a database connection
+type DatabaseCheck struct {
+	MachineName string
+	Driver      string
+	Tunnel      string        // tunnel machine name
+	TunnelTime  time.Duration // time to open the tunnel
+	ConfigTime  time.Duration // time to configure the driver
+	PingTime    time.Duration // time to ping, 0 for drivers without Pinger
+	Pinged      bool          // the driver implements driver.Pinger
+	Stage       string        // stage that failed, empty on success
+	Err         error
+}
+
+// CheckDatabase opens the tunnel of a database, configures its driver
+// and pings it if the driver implements driver.Pinger, timing each
+// stage. A driver panic is reported as the error of its stage.
+func (r *runner) CheckDatabase(machineName string) (check DatabaseCheck) {
+	check.MachineName = machineName
+
+	defer func() {
+		if p := recover(); p != nil {
+			check.Err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	check.Stage = CheckConfigure
+	db, ok := r.Cfg.Project.Databases[machineName]
+	if ok != true {
+		check.Err = errors.New("no database found for " + machineName)
+		return check
+	}
+
+	check.Driver = db.Driver
+	check.Tunnel = db.Tunnel
+
+	check.Stage = CheckTunnel
+	start := time.Now()
+	err := r.tunnel(db)
+	check.TunnelTime = time.Since(start)
+	if err != nil {
+		check.Err = err
+		return check
+	}
+
+	check.Stage = CheckConfigure
+	start = time.Now()
+	d, err := r.configureDriver("", db)
+	check.ConfigTime = time.Since(start)
+	if err != nil {
+		check.Err = err
+		return check
+	}
+
+	if pinger, ok := d.(driver.Pinger); ok {
+		check.Pinged = true
+		check.Stage = CheckPing
+		start = time.Now()
+		err = pinger.Ping()
+		check.PingTime = time.Since(start)
+		if err != nil {
+			check.Err = err
+			return check
+		}
+	}
+
+	check.Stage = ""
+	return check
+}
@@ -0,0 +1,386 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/txn2/dmk/cfg"
+	"github.com/txn2/dmk/driver"
+	"github.com/txn2/dmk/tunnel"
+	"gopkg.in/yaml.v2"
+)
+
+// tunnelVarRx matches tunnel variables like ${tunnel.local} and
+// escaped variables like $${tunnel.local}
+var tunnelVarRx = regexp.MustCompile(`\$?\$\{tunnel\.([A-Za-z]+)\}`)
+
+// expandFunc returns the expansion of a string value
+type expandFunc func(s string) (string, error)
+
+// expandRef records a value changed by expansion or an environment
+// overlay so the original value can be restored when a project is
+// saved.
+type expandRef struct {
+	path   []interface{} // map keys and list indexes to the value
+	raw    interface{}   // value before expansion
+	value  interface{}   // value after expansion
+	absent bool          // the value did not exist before expansion
+}
+
+// expandConfig returns a copy of a driver configuration with every
+// string value, including values in nested maps and lists, expanded
+// by fn.
+func expandConfig(config driver.Config, fn expandFunc) (driver.Config, error) {
+	expanded := make(driver.Config, len(config))
+
+	for k, v := range config {
+		ev, err := expandValue(v, []interface{}{k}, fn, nil)
+		if err != nil {
+			return nil, err
+		}
+		expanded[k] = ev
+	}
+
+	return expanded, nil
+}
+
+// expandValue expands strings in a configuration value, changed
+// strings are recorded in refs if it is not nil.
+func expandValue(v interface{}, path []interface{}, fn expandFunc, refs *[]expandRef) (interface{}, error) {
+	switch tv := v.(type) {
+	case string:
+		ev, err := fn(tv)
+		if err != nil {
+			return nil, errors.New(pathString(path) + ": " + err.Error())
+		}
+		if refs != nil && ev != tv {
+			*refs = append(*refs, expandRef{
+				path:  append([]interface{}{}, path...),
+				raw:   tv,
+				value: ev,
+			})
+		}
+		return ev, nil
+	case yaml.MapSlice:
+		m := make(yaml.MapSlice, len(tv))
+		for i, item := range tv {
+			ev, err := expandValue(item.Value, append(path, item.Key), fn, refs)
+			if err != nil {
+				return nil, err
+			}
+			m[i] = yaml.MapItem{Key: item.Key, Value: ev}
+		}
+		return m, nil
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(tv))
+		for k, mv := range tv {
+			ev, err := expandValue(mv, append(path, k), fn, refs)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = ev
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(tv))
+		for i, lv := range tv {
+			ev, err := expandValue(lv, append(path, i), fn, refs)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = ev
+		}
+		return l, nil
+	}
+
+	return v, nil
+}
+
+// pathString formats a value path as dot separated keys
+func pathString(path []interface{}) string {
+	keys := make([]string, len(path))
+	for i, k := range path {
+		keys[i] = fmt.Sprintf("%v", k)
+	}
+	return strings.Join(keys, ".")
+}
+
+// projectVarRx matches project file references like ${DB_PASSWORD}
+// or ${file:/run/secrets/db_password} and escaped references like
+// $${DB_PASSWORD}
+var projectVarRx = regexp.MustCompile(`\$?\$\{([^}]*)\}`)
+
+// projectVars expands environment variable and file references, an
+// escaped reference $${NAME} expands to the literal ${NAME}. Tunnel
+// variables are left for the runner to expand once the tunnel is
+// open.
+func projectVars(s string) (string, error) {
+	var err error
+
+	expanded := projectVarRx.ReplaceAllStringFunc(s, func(m string) string {
+		name := projectVarRx.FindStringSubmatch(m)[1]
+
+		if err != nil || strings.HasPrefix(name, "tunnel.") {
+			return m
+		}
+
+		if strings.HasPrefix(m, "$$") {
+			return m[1:]
+		}
+
+		if strings.HasPrefix(name, "file:") {
+			data, ferr := ioutil.ReadFile(tunnel.ExpandHome(strings.TrimPrefix(name, "file:")))
+			if ferr != nil {
+				err = errors.New("unresolved reference " + m + ": " + ferr.Error())
+				return m
+			}
+			return strings.TrimRight(string(data), "\r\n")
+		}
+
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+
+		err = errors.New("unresolved reference " + m + ": environment variable " + name + " is not set")
+		return m
+	})
+
+	return expanded, err
+}
+
+// expandedMigrationKeys are the migration values expanded when a
+// project is loaded
+var expandedMigrationKeys = map[interface{}]bool{
+	"sourceQuery":      true,
+	"sourceCountQuery": true,
+	"destinationQuery": true,
+}
+
+// expandProject expands references in the database configurations,
+// tunnels and migration queries of a project tree. The tree is
+// updated in place and the expanded values are returned.
+func expandProject(tree yaml.MapSlice, fn expandFunc) ([]expandRef, error) {
+	refs := make([]expandRef, 0)
+
+	for _, section := range tree {
+		components, ok := section.Value.(yaml.MapSlice)
+		if ok == false {
+			continue
+		}
+
+		for c, component := range components {
+			path := []interface{}{section.Key, component.Key}
+
+			switch section.Key {
+			case "tunnels":
+				ev, err := expandValue(component.Value, path, fn, &refs)
+				if err != nil {
+					return nil, err
+				}
+				components[c].Value = ev
+			case "databases", "migrations":
+				values, ok := component.Value.(yaml.MapSlice)
+				if ok == false {
+					continue
+				}
+
+				for i, item := range values {
+					if section.Key == "databases" && item.Key != "configuration" {
+						continue
+					}
+					if section.Key == "migrations" && expandedMigrationKeys[item.Key] == false {
+						continue
+					}
+
+					ev, err := expandValue(item.Value, append(path, item.Key), fn, &refs)
+					if err != nil {
+						return nil, err
+					}
+					values[i].Value = ev
+				}
+			}
+		}
+	}
+
+	return refs, nil
+}
+
+// restoreRefs replaces expanded values in a project tree with their
+// original values, in the reverse order they were expanded. Values
+// changed since loading are kept. Values added by an overlay are
+// removed.
+func restoreRefs(tree yaml.MapSlice, refs []expandRef) yaml.MapSlice {
+	for i := len(refs) - 1; i >= 0; i-- {
+		if restored, ok := restoreRef(tree, refs[i].path, refs[i]).(yaml.MapSlice); ok {
+			tree = restored
+		}
+	}
+
+	return tree
+}
+
+// removedRef marks a value to be removed from its parent
+var removedRef = &struct{}{}
+
+// restoreRef restores a single ref below node and returns the node
+func restoreRef(node interface{}, path []interface{}, ref expandRef) interface{} {
+	if len(path) == 0 {
+		if ref.absent {
+			return removedRef
+		}
+		if reflect.DeepEqual(node, ref.value) {
+			return ref.raw
+		}
+		return node
+	}
+
+	switch n := node.(type) {
+	case yaml.MapSlice:
+		for j := range n {
+			if n[j].Key != path[0] {
+				continue
+			}
+
+			v := restoreRef(n[j].Value, path[1:], ref)
+			if v == removedRef {
+				return append(n[:j], n[j+1:]...)
+			}
+			n[j].Value = v
+			return n
+		}
+	case []interface{}:
+		if idx, ok := path[0].(int); ok && idx < len(n) {
+			if v := restoreRef(n[idx], path[1:], ref); v != removedRef {
+				n[idx] = v
+			}
+		}
+	}
+
+	return node
+}
+
+// overlaySections are the project sections an environment overlay
+// may set
+var overlaySections = map[interface{}]bool{
+	"databases": true,
+	"tunnels":   true,
+}
+
+// mergeOverlay merges an environment overlay into a project tree.
+// Maps are merged key by key, any other overlay value replaces the
+// value in the tree. The replaced values are returned.
+func mergeOverlay(tree yaml.MapSlice, overlay yaml.MapSlice) (yaml.MapSlice, []expandRef, error) {
+	refs := make([]expandRef, 0)
+
+	for _, section := range overlay {
+		if overlaySections[section.Key] == false {
+			return nil, nil, fmt.Errorf("overlays may only set databases and tunnels, found %v", section.Key)
+		}
+
+		// components an overlay adds are recorded individually
+		found := false
+		for _, item := range tree {
+			found = found || item.Key == section.Key
+		}
+		if found == false {
+			tree = append(tree, yaml.MapItem{Key: section.Key, Value: yaml.MapSlice{}})
+		}
+	}
+
+	merged := mergeValue(tree, true, overlay, []interface{}{}, &refs)
+
+	return merged.(yaml.MapSlice), refs, nil
+}
+
+// mergeValue merges an overlay value into a base value
+func mergeValue(base interface{}, found bool, overlay interface{}, path []interface{}, refs *[]expandRef) interface{} {
+	bm, bok := base.(yaml.MapSlice)
+	om, ook := overlay.(yaml.MapSlice)
+
+	if bok == false || ook == false {
+		*refs = append(*refs, expandRef{
+			path:   append([]interface{}{}, path...),
+			raw:    base,
+			value:  overlay,
+			absent: found == false,
+		})
+		return overlay
+	}
+
+	for _, item := range om {
+		itemPath := append(append([]interface{}{}, path...), item.Key)
+
+		idx := -1
+		for j := range bm {
+			if bm[j].Key == item.Key {
+				idx = j
+				break
+			}
+		}
+
+		if idx < 0 {
+			bm = append(bm, yaml.MapItem{Key: item.Key, Value: mergeValue(nil, false, item.Value, itemPath, refs)})
+			continue
+		}
+
+		bm[idx].Value = mergeValue(bm[idx].Value, true, item.Value, itemPath, refs)
+	}
+
+	return bm
+}
+
+// tunnelVars returns a function expanding the tunnel variables of a
+// database configuration with the bound local endpoint of its tunnel:
+//
+//	${tunnel.local}      host:port
+//	${tunnel.localHost}  host
+//	${tunnel.localPort}  port
+//
+// An escaped variable $${tunnel.local} expands to the literal
+// ${tunnel.local}.
+func (r *runner) tunnelVars(db cfg.Database) expandFunc {
+	return func(s string) (string, error) {
+		var err error
+
+		expanded := tunnelVarRx.ReplaceAllStringFunc(s, func(m string) string {
+			if err != nil {
+				return m
+			}
+
+			if strings.HasPrefix(m, "$$") {
+				return m[1:]
+			}
+
+			if db.Tunnel == "" {
+				err = errors.New(m + " is used but database " + db.Component.MachineName + " has no tunnel")
+				return m
+			}
+
+			local, lerr := r.Cfg.TunnelManager.Local(db.Tunnel)
+			if lerr != nil {
+				err = lerr
+				return m
+			}
+
+			switch tunnelVarRx.FindStringSubmatch(m)[1] {
+			case "local":
+				return local.String()
+			case "localHost":
+				return local.Host
+			case "localPort":
+				return strconv.Itoa(local.Port)
+			}
+
+			err = errors.New("unknown tunnel variable " + m + ", use ${tunnel.local}, ${tunnel.localHost} or ${tunnel.localPort}")
+			return m
+		})
+
+		return expanded, err
+	}
+}
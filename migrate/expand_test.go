@@ -0,0 +1,294 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// testTree unmarshals a project tree
+func testTree(t *testing.T, s string) yaml.MapSlice {
+	tree := yaml.MapSlice{}
+	if err := yaml.Unmarshal([]byte(s), &tree); err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// treeString marshals a project tree for comparison
+func treeString(t *testing.T, tree yaml.MapSlice) string {
+	data, err := yaml.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestProjectVars tests the expansion of environment variable, file
+// and escaped references.
+func TestProjectVars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dmk-expand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secretFile := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("from file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("DMK_TEST_USER", "dmk")
+	os.Unsetenv("DMK_TEST_UNSET")
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"plain", "plain", false},
+		{"${DMK_TEST_USER}", "dmk", false},
+		{"user=${DMK_TEST_USER}, again ${DMK_TEST_USER}", "user=dmk, again dmk", false},
+		{"${file:" + secretFile + "}", "from file", false},
+		{"$${DMK_TEST_USER}", "${DMK_TEST_USER}", false},
+		{"$${DMK_TEST_UNSET} ${DMK_TEST_USER}", "${DMK_TEST_UNSET} dmk", false},
+		{"$$${DMK_TEST_USER}", "$${DMK_TEST_USER}", false},
+		{"${tunnel.local}", "${tunnel.local}", false},
+		{"$${tunnel.local}", "$${tunnel.local}", false},
+		{"${DMK_TEST_UNSET}", "", true},
+		{"${file:" + filepath.Join(dir, "missing") + "}", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := projectVars(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: got error %v, want error %t", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestRestoreRefs tests expanded values are saved as their
+// references unless they changed after loading.
+func TestRestoreRefs(t *testing.T) {
+	os.Setenv("DMK_TEST_USER", "dmk")
+	os.Setenv("DMK_TEST_HOST", "db.example.com")
+
+	tests := []struct {
+		name   string
+		tree   string
+		change func(tree yaml.MapSlice)
+		want   string
+	}{
+		{
+			name: "unchanged",
+			tree: `databases:
+  db:
+    configuration:
+      username: ${DMK_TEST_USER}
+      hosts:
+      - ${DMK_TEST_HOST}
+      - other
+      port: 3306
+`,
+		},
+		{
+			name: "escaped",
+			tree: `migrations:
+  m:
+    sourceQuery: select '$${DMK_TEST_USER}'
+    transformationScript: ${DMK_TEST_USER}
+`,
+		},
+		{
+			name: "changed",
+			tree: `databases:
+  db:
+    configuration:
+      username: ${DMK_TEST_USER}
+      password: ${DMK_TEST_USER}
+`,
+			change: func(tree yaml.MapSlice) {
+				db := mapValue(mapValue(tree, "databases").(yaml.MapSlice), "db").(yaml.MapSlice)
+				config := mapValue(db, "configuration").(yaml.MapSlice)
+				setMapValue(config, "username", "changed")
+			},
+			want: `databases:
+  db:
+    configuration:
+      username: changed
+      password: ${DMK_TEST_USER}
+`,
+		},
+		{
+			name: "tunnel",
+			tree: `tunnels:
+  t:
+    server:
+      host: ${DMK_TEST_HOST}
+      port: 22
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		want := tt.want
+		if want == "" {
+			want = tt.tree
+		}
+
+		tree := testTree(t, tt.tree)
+
+		refs, err := expandProject(tree, projectVars)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if len(refs) == 0 {
+			t.Errorf("%s: no references were expanded", tt.name)
+		}
+
+		if tt.change != nil {
+			tt.change(tree)
+		}
+
+		got := treeString(t, restoreRefs(tree, refs))
+		if got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, want)
+		}
+	}
+}
+
+// TestMergeOverlay tests overlays are merged key by key and the
+// project values are restored for saving.
+func TestMergeOverlay(t *testing.T) {
+	base := `databases:
+  db:
+    driver: mysql
+    configuration:
+      databaseHost: localhost
+      databasePort: "3306"
+      credentials:
+        password: ${DMK_TEST_PASSWORD}
+migrations:
+  m:
+    sourceDb: db
+`
+
+	tests := []struct {
+		name    string
+		overlay string
+		want    string
+		restore string // restored tree, the base if empty
+		wantErr bool
+	}{
+		{
+			name: "nested keys",
+			overlay: `databases:
+  db:
+    configuration:
+      databaseHost: db.prod.example.com
+      credentials:
+        username: prod
+`,
+			want: `databases:
+  db:
+    driver: mysql
+    configuration:
+      databaseHost: db.prod.example.com
+      databasePort: "3306"
+      credentials:
+        password: ${DMK_TEST_PASSWORD}
+        username: prod
+migrations:
+  m:
+    sourceDb: db
+`,
+		},
+		{
+			name: "new components",
+			overlay: `databases:
+  extra:
+    driver: csv
+tunnels:
+  t:
+    server:
+      host: bastion
+`,
+			want: `databases:
+  db:
+    driver: mysql
+    configuration:
+      databaseHost: localhost
+      databasePort: "3306"
+      credentials:
+        password: ${DMK_TEST_PASSWORD}
+  extra:
+    driver: csv
+migrations:
+  m:
+    sourceDb: db
+tunnels:
+  t:
+    server:
+      host: bastion
+`,
+			// the added section is kept for components added after loading
+			restore: base + "tunnels: {}\n",
+		},
+		{
+			name: "replaced map",
+			overlay: `databases:
+  db:
+    configuration: null
+`,
+			want: `databases:
+  db:
+    driver: mysql
+    configuration: null
+migrations:
+  m:
+    sourceDb: db
+`,
+		},
+		{
+			name: "migrations",
+			overlay: `migrations:
+  m:
+    sourceDb: other
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tree := testTree(t, base)
+
+		merged, refs, err := mergeOverlay(tree, testTree(t, tt.overlay))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %t", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if got := treeString(t, merged); got != tt.want {
+			t.Errorf("%s: merged\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+
+		restore := tt.restore
+		if restore == "" {
+			restore = base
+		}
+
+		if got := treeString(t, restoreRefs(merged, refs)); got != restore {
+			t.Errorf("%s: restored\n%s\nwant\n%s", tt.name, got, restore)
+		}
+	}
+}
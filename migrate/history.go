@@ -0,0 +1,292 @@
+package migrate
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.uber.org/zap"
+)
+
+// Run statuses of a history entry and a run summary
+const (
+	RunRunning        = "running" // the run has not finished, or dmk exited during it
+	RunSuccess        = "success"
+	RunSetupFailed    = "setupFailed"    // nothing was written
+	RunPartialFailure = "partialFailure" // records may have been written
+)
+
+// historyBucket holds the history entries keyed by id
+var historyBucket = []byte("runs")
+
+// RunStatus returns the status of a finished run
+func RunStatus(runResult *RunResult, err error) string {
+	if err == nil {
+		return RunSuccess
+	}
+
+	if runResult != nil && runResult.SetupDone {
+		return RunPartialFailure
+	}
+
+	return RunSetupFailed
+}
+
+// HistoryEntry records a run of a migration. Migrations run by a
+// transformation script with run() have the id of the run they are
+// part of as ParentID.
+type HistoryEntry struct {
+	ID        uint64     `json:"id"`
+	ParentID  uint64     `json:"parentId,omitempty"`
+	Migration string     `json:"migration"`
+	Args      []string   `json:"args"`
+	Env       string     `json:"env,omitempty"`
+	DryRun    bool       `json:"dryRun,omitempty"`
+	Version   string     `json:"version"` // dmk version
+	Started   time.Time  `json:"started"`
+	Finished  time.Time  `json:"finished"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	Result    *RunResult `json:"result,omitempty"` // counts and durations
+}
+
+// HistoryDbFile returns the run history database of a project
+func HistoryDbFile(basePath string, project string) string {
+	return basePath + project + "-dmk-history.db"
+}
+
+// History is the run history of a project stored in a local
+// database. The database is opened for each read or write so that
+// concurrent dmk processes can share it.
+type History struct {
+	dbFile string
+}
+
+// NewHistory returns the run history stored in a database file
+func NewHistory(dbFile string) *History {
+	return &History{dbFile: dbFile}
+}
+
+// update runs fn in a read-write transaction on the history bucket
+func (h *History) update(fn func(b *bolt.Bucket) error) error {
+	db, err := bolt.Open(h.dbFile, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(historyBucket)
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+// view runs fn in a read-only transaction on the history bucket, fn
+// is not called if there is no history.
+func (h *History) view(fn func(b *bolt.Bucket) error) error {
+	if _, err := os.Stat(h.dbFile); os.IsNotExist(err) {
+		return nil
+	}
+
+	db, err := bolt.Open(h.dbFile, 0600, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		if b == nil {
+			return nil
+		}
+		return fn(b)
+	})
+}
+
+// historyKey converts an id to a key that sorts in id order
+func historyKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// Add stores a new entry and sets its id
+func (h *History) Add(entry *HistoryEntry) error {
+	return h.update(func(b *bolt.Bucket) error {
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = id
+
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		return b.Put(historyKey(id), value)
+	})
+}
+
+// Update replaces a stored entry
+func (h *History) Update(entry HistoryEntry) error {
+	return h.update(func(b *bolt.Bucket) error {
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		return b.Put(historyKey(entry.ID), value)
+	})
+}
+
+// Get returns an entry by id
+func (h *History) Get(id uint64) (HistoryEntry, error) {
+	entry := HistoryEntry{}
+	found := false
+
+	err := h.view(func(b *bolt.Bucket) error {
+		value := b.Get(historyKey(id))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, &entry)
+	})
+	if err != nil {
+		return entry, err
+	}
+
+	if found == false {
+		return entry, errors.New("no run found with id " + strconv.FormatUint(id, 10))
+	}
+
+	return entry, nil
+}
+
+// List returns entries newest first. Only runs of migration are
+// returned unless it is empty, at most limit entries unless it is 0.
+func (h *History) List(migration string, limit int) ([]HistoryEntry, error) {
+	entries := make([]HistoryEntry, 0)
+
+	err := h.view(func(b *bolt.Bucket) error {
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			entry := HistoryEntry{}
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+
+			if migration != "" && entry.Migration != migration {
+				continue
+			}
+
+			entries = append(entries, entry)
+			if limit > 0 && len(entries) == limit {
+				return nil
+			}
+		}
+		return nil
+	})
+
+	return entries, err
+}
+
+// Children returns the entries of the runs started by a run, in the
+// order they ran
+func (h *History) Children(id uint64) ([]HistoryEntry, error) {
+	entries := make([]HistoryEntry, 0)
+
+	err := h.view(func(b *bolt.Bucket) error {
+		c := b.Cursor()
+		for k, v := c.Seek(historyKey(id + 1)); k != nil; k, v = c.Next() {
+			entry := HistoryEntry{}
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+
+			if entry.ParentID == id {
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+
+	return entries, err
+}
+
+// startHistory records the start of a run, the entry is nil if the
+// run is not recorded
+func (r *runner) startHistory(machineName string, sourceArgs []string) *HistoryEntry {
+	if r.Cfg.NoHistory || r.Cfg.Project.Component.MachineName == "" {
+		return nil
+	}
+
+	entry := &HistoryEntry{
+		Migration: machineName,
+		Args:      sourceArgs,
+		Env:       r.Cfg.Project.Env(),
+		DryRun:    r.Cfg.DryRun,
+		Version:   r.Cfg.Version,
+		Started:   time.Now(),
+		Status:    RunRunning,
+	}
+
+	// a migration run by a script is part of the running migration
+	if len(r.runIDs) > 0 {
+		entry.ParentID = r.runIDs[len(r.runIDs)-1]
+	}
+
+	history := NewHistory(HistoryDbFile(r.Cfg.Path, r.Cfg.Project.Component.MachineName))
+	err := history.Add(entry)
+	if err != nil {
+		r.Log.Warn("HistoryError",
+			zap.String("Type", "Setup"),
+			zap.String("MachineName", machineName),
+			zap.Error(err),
+		)
+		return nil
+	}
+
+	r.runIDs = append(r.runIDs, entry.ID)
+
+	return entry
+}
+
+// finishHistory records the result of a run
+func (r *runner) finishHistory(entry *HistoryEntry, runResult *RunResult, err error) {
+	if entry == nil {
+		return
+	}
+
+	r.runIDs = r.runIDs[:len(r.runIDs)-1]
+
+	entry.Finished = time.Now()
+	entry.Status = RunStatus(runResult, err)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	if runResult != nil {
+		result := *runResult
+		result.Previews = nil
+		entry.Result = &result
+	}
+
+	history := NewHistory(HistoryDbFile(r.Cfg.Path, r.Cfg.Project.Component.MachineName))
+	err = history.Update(*entry)
+	if err != nil {
+		r.Log.Warn("HistoryError",
+			zap.String("Type", "Done"),
+			zap.String("MachineName", entry.Migration),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,152 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testHistory returns a History with runs of a, b and a run of a
+// that started runs of b and c:
+//
+//	1 a, 2 b, 3 a, 4 b (parent 3), 5 c (parent 3), 6 b
+func testHistory(t *testing.T) (*History, func()) {
+	dir, err := ioutil.TempDir("", "dmk-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewHistory(filepath.Join(dir, "test-dmk-history.db"))
+
+	runs := []HistoryEntry{
+		{Migration: "a"},
+		{Migration: "b"},
+		{Migration: "a"},
+		{Migration: "b", ParentID: 3},
+		{Migration: "c", ParentID: 3},
+		{Migration: "b"},
+	}
+
+	for i := range runs {
+		if err := h.Add(&runs[i]); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+
+	return h, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+// historyIDs returns the ids of history entries
+func historyIDs(entries []HistoryEntry) []uint64 {
+	ids := make([]uint64, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	return ids
+}
+
+// equalIDs returns true if two id lists are equal
+func equalIDs(a []uint64, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestHistoryList tests entries are listed newest first, filtered
+// by migration and limited.
+func TestHistoryList(t *testing.T) {
+	h, done := testHistory(t)
+	defer done()
+
+	tests := []struct {
+		name      string
+		migration string
+		limit     int
+		want      []uint64
+	}{
+		{"all", "", 0, []uint64{6, 5, 4, 3, 2, 1}},
+		{"limit", "", 2, []uint64{6, 5}},
+		{"migration", "b", 0, []uint64{6, 4, 2}},
+		{"migration limit", "a", 1, []uint64{3}},
+		{"limit over count", "c", 5, []uint64{5}},
+		{"unknown migration", "d", 0, []uint64{}},
+	}
+
+	for _, tt := range tests {
+		entries, err := h.List(tt.migration, tt.limit)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		if got := historyIDs(entries); equalIDs(got, tt.want) == false {
+			t.Errorf("%s: got ids %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestHistoryChildren tests the runs started by a run are returned
+// in the order they ran.
+func TestHistoryChildren(t *testing.T) {
+	h, done := testHistory(t)
+	defer done()
+
+	tests := []struct {
+		id   uint64
+		want []uint64
+	}{
+		{3, []uint64{4, 5}},
+		{1, []uint64{}},
+		{4, []uint64{}},
+		{99, []uint64{}},
+	}
+
+	for _, tt := range tests {
+		entries, err := h.Children(tt.id)
+		if err != nil {
+			t.Fatalf("%d: %s", tt.id, err)
+		}
+
+		if got := historyIDs(entries); equalIDs(got, tt.want) == false {
+			t.Errorf("%d: got ids %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+// TestHistoryEmpty tests a project without a history database has
+// no entries and the database is not created by reading.
+func TestHistoryEmpty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dmk-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dbFile := filepath.Join(dir, "test-dmk-history.db")
+	h := NewHistory(dbFile)
+
+	entries, err := h.List("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d entries, want 0", len(entries))
+	}
+
+	if _, err := h.Get(1); err == nil {
+		t.Errorf("expected an error getting a missing run")
+	}
+
+	if _, err := os.Stat(dbFile); os.IsNotExist(err) == false {
+		t.Errorf("reading the history created %s", dbFile)
+	}
+}
@@ -0,0 +1,359 @@
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ProjectDirFile is the file in a project directory holding the
+// project component.
+const ProjectDirFile = "project.yml"
+
+// projectSections are the project sections kept in a sub directory
+// of a project directory, one file per component.
+var projectSections = []string{"databases", "migrations", "tunnels"}
+
+// migrationFileKeys maps migration file reference keys to the key
+// of the value loaded from the file.
+var migrationFileKeys = yaml.MapSlice{
+	{Key: "sourceQueryFile", Value: "sourceQuery"},
+	{Key: "sourceCountQueryFile", Value: "sourceCountQuery"},
+	{Key: "destinationQueryFile", Value: "destinationQuery"},
+	{Key: "transformationScriptFile", Value: "transformationScript"},
+}
+
+// ProjectFile returns the project directory <project>-dmk if it
+// exists in dir, otherwise the project file <project>-dmk.yml.
+//
+// A project directory contains:
+//
+//	project.yml        the project component
+//	databases/*.yml    a database per file
+//	migrations/*.yml   a migration per file
+//	tunnels/*.yml      a tunnel per file
+//	scripts/*.js       transformation scripts referenced by migrations
+//	queries/*.cql      queries referenced by migrations
+func ProjectFile(dir string, machineName string) string {
+	projectDir := dir + machineName + "-dmk"
+	if isDir(projectDir) {
+		return projectDir
+	}
+
+	return projectDir + ".yml"
+}
+
+// isDir returns true if path is a directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// ProjectBase returns the directory paths in a project file or
+// directory are relative to.
+func ProjectBase(filename string) string {
+	if isDir(filename) {
+		return filename
+	}
+
+	return filepath.Dir(filename)
+}
+
+// readProjectTree reads a project file or directory
+func readProjectTree(filename string) (yaml.MapSlice, error) {
+	tree := yaml.MapSlice{}
+
+	if isDir(filename) == false {
+		err := readYaml(filename, &tree)
+		return tree, err
+	}
+
+	err := readYaml(filepath.Join(filename, ProjectDirFile), &tree)
+	if err != nil {
+		return tree, err
+	}
+
+	for _, section := range projectSections {
+		files, err := filepath.Glob(filepath.Join(filename, section, "*.yml"))
+		if err != nil {
+			return tree, err
+		}
+
+		components := yaml.MapSlice{}
+		idx := -1
+
+		for i, item := range tree {
+			if item.Key == section {
+				components, _ = item.Value.(yaml.MapSlice)
+				idx = i
+			}
+		}
+
+		sort.Strings(files)
+
+		for _, file := range files {
+			component := yaml.MapSlice{}
+			err := readYaml(file, &component)
+			if err != nil {
+				return tree, err
+			}
+
+			machineName := strings.TrimSuffix(filepath.Base(file), ".yml")
+			components = append(components, yaml.MapItem{Key: machineName, Value: component})
+		}
+
+		if idx < 0 {
+			tree = append(tree, yaml.MapItem{Key: section, Value: components})
+			continue
+		}
+		tree[idx].Value = components
+	}
+
+	return tree, nil
+}
+
+// readYaml reads and unmarshals a yaml file
+func readYaml(filename string, out interface{}) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	err = yaml.Unmarshal(data, out)
+	if err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+
+	return nil
+}
+
+// unmarshalTree unmarshals a project tree into a project
+func unmarshalTree(tree yaml.MapSlice, project *Project) error {
+	data, err := yaml.Marshal(tree)
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal(data, project)
+}
+
+// readMigrationFiles loads the queries and scripts migrations
+// reference by path into a project tree.
+func readMigrationFiles(tree yaml.MapSlice, base string) error {
+	return eachMigration(tree, func(machineName interface{}, migration yaml.MapSlice) (yaml.MapSlice, error) {
+		for _, fileKey := range migrationFileKeys {
+			file, _ := mapValue(migration, fileKey.Key).(string)
+			if file == "" {
+				continue
+			}
+
+			data, err := ioutil.ReadFile(filepath.Join(base, file))
+			if err != nil {
+				return nil, fmt.Errorf("migration %v: %s", machineName, err)
+			}
+
+			migration = setMapValue(migration, fileKey.Value, string(data))
+		}
+
+		return migration, nil
+	})
+}
+
+// writeMigrationFiles writes the queries and scripts migrations
+// reference by path and removes them from the project tree. Existing
+// files are not written with empty content. The paths of changed
+// files are returned.
+func writeMigrationFiles(tree yaml.MapSlice, base string) ([]string, error) {
+	written := make([]string, 0)
+
+	err := eachMigration(tree, func(machineName interface{}, migration yaml.MapSlice) (yaml.MapSlice, error) {
+		for _, fileKey := range migrationFileKeys {
+			file, _ := mapValue(migration, fileKey.Key).(string)
+			if file == "" {
+				continue
+			}
+
+			content, _ := mapValue(migration, fileKey.Value).(string)
+
+			// an existing file is never emptied, its content may
+			// not have been loaded
+			path := filepath.Join(base, file)
+			if _, err := os.Stat(path); content != "" || err != nil {
+				changed, err := writeIfChanged(path, []byte(content))
+				if err != nil {
+					return nil, fmt.Errorf("migration %v: %s", machineName, err)
+				}
+				if changed {
+					written = append(written, path)
+				}
+			}
+
+			migration = deleteMapValue(migration, fileKey.Value)
+		}
+
+		return migration, nil
+	})
+
+	return written, err
+}
+
+// eachMigration calls fn with each migration of a project tree,
+// replacing the migration with the one fn returns.
+func eachMigration(tree yaml.MapSlice, fn func(machineName interface{}, migration yaml.MapSlice) (yaml.MapSlice, error)) error {
+	migrations, _ := mapValue(tree, "migrations").(yaml.MapSlice)
+
+	for i, item := range migrations {
+		migration, ok := item.Value.(yaml.MapSlice)
+		if ok == false {
+			continue
+		}
+
+		migration, err := fn(item.Key, migration)
+		if err != nil {
+			return err
+		}
+		migrations[i].Value = migration
+	}
+
+	return nil
+}
+
+// mapValue returns the value of a key, nil if it does not exist
+func mapValue(m yaml.MapSlice, key interface{}) interface{} {
+	for _, item := range m {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+// setMapValue sets the value of a key, adding the key if needed
+func setMapValue(m yaml.MapSlice, key interface{}, value interface{}) yaml.MapSlice {
+	for i, item := range m {
+		if item.Key == key {
+			m[i].Value = value
+			return m
+		}
+	}
+	return append(m, yaml.MapItem{Key: key, Value: value})
+}
+
+// deleteMapValue removes a key
+func deleteMapValue(m yaml.MapSlice, key interface{}) yaml.MapSlice {
+	for i, item := range m {
+		if item.Key == key {
+			return append(m[:i], m[i+1:]...)
+		}
+	}
+	return m
+}
+
+// writeIfChanged writes data to a file if the file content is
+// different, creating directories as needed. The return value is
+// true if the file was written.
+func writeIfChanged(filename string, data []byte) (bool, error) {
+	current, err := ioutil.ReadFile(filename)
+	if err == nil && bytes.Equal(current, data) {
+		return false, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return false, err
+	}
+
+	return true, ioutil.WriteFile(filename, data, 0644)
+}
+
+// SaveProject saves a project to a project file or, if filename is
+// a directory, to a project directory (see ProjectFile). Queries and
+// scripts migrations reference by path are written to their files.
+// Only files with changed content are written, the paths of written
+// and removed files are returned.
+func SaveProject(filename string, project Project) ([]string, error) {
+	data, err := yaml.Marshal(project)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := yaml.MapSlice{}
+	err = yaml.Unmarshal(data, &tree)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := writeMigrationFiles(tree, ProjectBase(filename))
+	if err != nil {
+		return changed, err
+	}
+
+	if isDir(filename) == false {
+		data, err := yaml.Marshal(tree)
+		if err != nil {
+			return changed, err
+		}
+
+		written, err := writeIfChanged(filename, data)
+		if written {
+			changed = append(changed, filename)
+		}
+		return changed, err
+	}
+
+	for _, section := range projectSections {
+		components, _ := mapValue(tree, section).(yaml.MapSlice)
+		tree = deleteMapValue(tree, section)
+
+		keep := make(map[string]bool, len(components))
+
+		for _, component := range components {
+			file := filepath.Join(filename, section, fmt.Sprintf("%v", component.Key)+".yml")
+			keep[file] = true
+
+			data, err := yaml.Marshal(component.Value)
+			if err != nil {
+				return changed, err
+			}
+
+			written, err := writeIfChanged(file, data)
+			if err != nil {
+				return changed, err
+			}
+			if written {
+				changed = append(changed, file)
+			}
+		}
+
+		// remove the files of deleted components
+		files, _ := filepath.Glob(filepath.Join(filename, section, "*.yml"))
+		for _, file := range files {
+			if keep[file] {
+				continue
+			}
+			if err := os.Remove(file); err != nil {
+				return changed, err
+			}
+			changed = append(changed, file)
+		}
+	}
+
+	data, err = yaml.Marshal(tree)
+	if err != nil {
+		return changed, err
+	}
+
+	file := filepath.Join(filename, ProjectDirFile)
+	written, err := writeIfChanged(file, data)
+	if written {
+		changed = append(changed, file)
+	}
+
+	return changed, err
+}
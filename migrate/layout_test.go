@@ -0,0 +1,163 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/txn2/dmk/cfg"
+)
+
+// testProject returns a project with a database and a migration
+// keeping its script in a file
+func testProject() Project {
+	return Project{
+		Component: cfg.Component{Name: "Test", MachineName: "test"},
+		Databases: map[string]cfg.Database{
+			"db": {Component: cfg.Component{MachineName: "db"}, Driver: "csv"},
+		},
+		Migrations: map[string]cfg.Migration{
+			"m": {
+				Component:                cfg.Component{MachineName: "m"},
+				SourceDb:                 "db",
+				TransformationScript:     "true;\n",
+				TransformationScriptFile: "scripts/m.js",
+			},
+		},
+		Tunnels: map[string]cfg.Tunnel{},
+	}
+}
+
+// TestSaveProject tests only changed files are written, for both a
+// project file and a project directory, and the files of deleted
+// components are removed.
+func TestSaveProject(t *testing.T) {
+	steps := []struct {
+		name     string
+		change   func(p *Project)
+		wantFile []string // changed paths saving to a project file
+		wantDir  []string // changed paths saving to a project directory
+		removed  bool     // the changed directory files are removed
+	}{
+		{
+			name:     "first save",
+			wantFile: []string{"scripts/m.js", "test-dmk.yml"},
+			wantDir:  []string{"test-dmk/databases/db.yml", "test-dmk/migrations/m.yml", "test-dmk/project.yml", "test-dmk/scripts/m.js"},
+		},
+		{
+			name:     "unchanged",
+			wantFile: []string{},
+			wantDir:  []string{},
+		},
+		{
+			name: "changed script",
+			change: func(p *Project) {
+				m := p.Migrations["m"]
+				m.TransformationScript = "false;\n"
+				p.Migrations["m"] = m
+			},
+			wantFile: []string{"scripts/m.js"},
+			wantDir:  []string{"test-dmk/scripts/m.js"},
+		},
+		{
+			name: "empty script",
+			change: func(p *Project) {
+				m := p.Migrations["m"]
+				m.TransformationScript = ""
+				p.Migrations["m"] = m
+			},
+			wantFile: []string{},
+			wantDir:  []string{},
+		},
+		{
+			name: "added database",
+			change: func(p *Project) {
+				p.Databases["other"] = cfg.Database{Component: cfg.Component{MachineName: "other"}, Driver: "csv"}
+			},
+			wantFile: []string{"test-dmk.yml"},
+			wantDir:  []string{"test-dmk/databases/other.yml"},
+		},
+		{
+			name: "deleted database",
+			change: func(p *Project) {
+				delete(p.Databases, "db")
+			},
+			wantFile: []string{"test-dmk.yml"},
+			wantDir:  []string{"test-dmk/databases/db.yml"},
+			removed:  true,
+		},
+		{
+			name: "changed project",
+			change: func(p *Project) {
+				p.Component.Description = "changed"
+			},
+			wantFile: []string{"test-dmk.yml"},
+			wantDir:  []string{"test-dmk/project.yml"},
+		},
+	}
+
+	for _, layout := range []string{"file", "dir"} {
+		dir, err := ioutil.TempDir("", "dmk-layout")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filename := filepath.Join(dir, "test-dmk.yml")
+		if layout == "dir" {
+			filename = filepath.Join(dir, "test-dmk")
+			if err := os.Mkdir(filename, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		project := testProject()
+
+		for _, tt := range steps {
+			if tt.change != nil {
+				tt.change(&project)
+			}
+
+			written, err := SaveProject(filename, project)
+			if err != nil {
+				t.Fatalf("%s %s: %s", layout, tt.name, err)
+			}
+
+			got := make([]string, 0, len(written))
+			for _, path := range written {
+				rel, _ := filepath.Rel(dir, path)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			sort.Strings(got)
+
+			want := tt.wantFile
+			if layout == "dir" {
+				want = tt.wantDir
+			}
+
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("%s %s: got changed %v, want %v", layout, tt.name, got, want)
+			}
+
+			for _, path := range got {
+				_, err := os.Stat(filepath.Join(dir, path))
+				removed := tt.removed && layout == "dir"
+				if exists := err == nil; exists == removed {
+					t.Errorf("%s %s: %s exists is %t", layout, tt.name, path, exists)
+				}
+			}
+		}
+
+		loaded, err := LoadProject(filename)
+		if err != nil {
+			t.Fatalf("%s: %s", layout, err)
+		}
+		if len(loaded.Databases) != 1 || loaded.Migrations["m"].TransformationScript != "false;\n" || loaded.Component.Description != "changed" {
+			t.Errorf("%s: loaded project does not match the saved project", layout)
+		}
+
+		os.RemoveAll(dir)
+	}
+}
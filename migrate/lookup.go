@@ -0,0 +1,153 @@
+package migrate
+
+import (
+	"container/list"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/txn2/dmk/driver"
+	"go.uber.org/zap"
+)
+
+// lookupCache is a simple LRU cache of query results used by
+// the query() script function. A cache lives for a single run.
+type lookupCache struct {
+	size  int
+	items map[string]*list.Element
+	order *list.List
+	mux   sync.Mutex
+}
+
+// lookupCacheEntry is an element of lookupCache
+type lookupCacheEntry struct {
+	key     string
+	records []driver.Record
+}
+
+// newLookupCache creates a lookupCache holding at most size
+// results. A size of 0 or less returns nil (no caching).
+func newLookupCache(size int) *lookupCache {
+	if size <= 0 {
+		return nil
+	}
+
+	return &lookupCache{
+		size:  size,
+		items: make(map[string]*list.Element, size),
+		order: list.New(),
+	}
+}
+
+// get returns cached records for a key
+func (lc *lookupCache) get(key string) ([]driver.Record, bool) {
+	if lc == nil {
+		return nil, false
+	}
+
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+
+	if el, ok := lc.items[key]; ok {
+		lc.order.MoveToFront(el)
+		return el.Value.(*lookupCacheEntry).records, true
+	}
+
+	return nil, false
+}
+
+// set stores records for a key, evicting the least recently used
+// entry if the cache is full.
+func (lc *lookupCache) set(key string, records []driver.Record) {
+	if lc == nil {
+		return
+	}
+
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+
+	if el, ok := lc.items[key]; ok {
+		el.Value.(*lookupCacheEntry).records = records
+		lc.order.MoveToFront(el)
+		return
+	}
+
+	lc.items[key] = lc.order.PushFront(&lookupCacheEntry{key: key, records: records})
+
+	if lc.order.Len() > lc.size {
+		last := lc.order.Back()
+		lc.order.Remove(last)
+		delete(lc.items, last.Value.(*lookupCacheEntry).key)
+	}
+}
+
+// lookupKey makes a cache key from a database, query and args
+func lookupKey(dbMachineName string, query string, args []string) string {
+	return dbMachineName + "\x00" + query + "\x00" + strings.Join(args, "\x00")
+}
+
+// lookup runs a query against a configured database and returns
+// all of the resulting records.
+func (r *runner) lookup(dbMachineName string, query string, args []string, cache *lookupCache) ([]driver.Record, error) {
+	key := lookupKey(dbMachineName, query, args)
+
+	if records, ok := cache.get(key); ok {
+		return records, nil
+	}
+
+	db, ok := r.Cfg.Project.Databases[dbMachineName]
+	if ok != true {
+		return nil, errors.New("no database found for " + dbMachineName)
+	}
+
+	err := r.tunnel(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// lookups get their own driver so they do not
+	// re-initialize a driver used by a migration
+	d, err := r.configureDriver("lookup", db)
+	if err != nil {
+		return nil, err
+	}
+
+	recordChan, err := d.Out(query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]driver.Record, 0)
+	for record := range recordChan {
+		records = append(records, record)
+	}
+
+	err = driver.OutError(d)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.set(key, records)
+
+	return records, nil
+}
+
+// scriptLookup returns the query function for a script context
+func (r *runner) scriptLookup(machineName string, cache *lookupCache) func(string, string, []string) []driver.Record {
+	return func(dbMachineName string, query string, args []string) []driver.Record {
+		records, err := r.lookup(dbMachineName, query, args, cache)
+		if err != nil {
+			r.Log.Error("ScriptQueryError",
+				zap.String("Type", "ScriptQuery"),
+				zap.String("MachineName", machineName),
+				zap.String("Database", dbMachineName),
+				zap.String("Query", strings.Trim(query, "\n")),
+				zap.Strings("Args", args),
+				zap.Error(err),
+			)
+			return []driver.Record{}
+		}
+
+		return records
+	}
+}
@@ -1,7 +1,9 @@
 package migrate
 
 import (
-	"io/ioutil"
+	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/txn2/dmk/cfg"
 	"github.com/txn2/dmk/driver"
@@ -17,22 +19,140 @@ type Project struct {
 	Migrations    map[string]cfg.Migration // map of migration machine names to migrations
 	Tunnels       map[string]cfg.Tunnel    // map of tunnels
 	driverManager driver.Manager
-	tunnelManager tunnel.Manager
+	tunnelManager *tunnel.Manager
+	refs          []expandRef // expanded references, restored on save
+	env           string      // environment overlay
 }
 
-// LoadProject loads a project from yaml data
+// LoadProject loads a project from yaml data. References to
+// environment variables, ${ENV_VAR}, and files, ${file:/path}, in
+// database configurations, tunnels and migration queries are
+// expanded. The project is returned unexpanded with an error if a
+// reference can not be resolved.
 func LoadProject(filename string) (project Project, err error) {
-	ymlData, err := ioutil.ReadFile(filename)
+	return LoadProjectEnv(filename, "")
+}
+
+// LoadProjectEnv loads a project with the databases and tunnels of
+// an environment overlay file merged over it. See OverlayFile.
+//
+// The filename may be a project file or a project directory, see
+// ProjectFile.
+func LoadProjectEnv(filename string, env string) (project Project, err error) {
+	tree, err := readProjectTree(filename)
 	if err != nil {
 		return project, err
 	}
 
 	project = Project{}
 
-	err = yaml.Unmarshal([]byte(ymlData), &project)
+	err = unmarshalTree(tree, &project)
+	if err != nil {
+		return project, err
+	}
+
+	err = readMigrationFiles(tree, ProjectBase(filename))
+	if err != nil {
+		return project, err
+	}
+
+	refs := make([]expandRef, 0)
+
+	if env != "" {
+		overlayFile := OverlayFile(filename, env)
+
+		overlay := yaml.MapSlice{}
+		err := readYaml(overlayFile, &overlay)
+		if err != nil {
+			return project, fmt.Errorf("environment %s: %s", env, err)
+		}
+
+		tree, refs, err = mergeOverlay(tree, overlay)
+		if err != nil {
+			return project, fmt.Errorf("%s: %s", overlayFile, err)
+		}
+	}
+
+	expandRefs, err := expandProject(tree, projectVars)
+	if err != nil {
+		return project, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	refs = append(refs, expandRefs...)
+
+	project = Project{refs: refs, env: env}
+
+	err = unmarshalTree(tree, &project)
 	if err != nil {
 		return project, err
 	}
 
 	return project, nil
 }
+
+// DatabaseNames returns the sorted machine names of the databases
+func (p Project) DatabaseNames() []string {
+	names := make([]string, 0, len(p.Databases))
+	for k := range p.Databases {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MigrationNames returns the sorted machine names of the migrations
+func (p Project) MigrationNames() []string {
+	names := make([]string, 0, len(p.Migrations))
+	for k := range p.Migrations {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TunnelNames returns the sorted machine names of the tunnels
+func (p Project) TunnelNames() []string {
+	names := make([]string, 0, len(p.Tunnels))
+	for k := range p.Tunnels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OverlayFile returns the environment overlay file for a project
+// file or directory, ex: example-dmk.yml or example-dmk/ with env
+// prod is example.prod-dmk.yml
+func OverlayFile(filename string, env string) string {
+	base := strings.TrimSuffix(strings.TrimSuffix(filename, "/"), "-dmk.yml")
+	return strings.TrimSuffix(base, "-dmk") + "." + env + "-dmk.yml"
+}
+
+// Env returns the environment overlay the project was loaded
+// with, an empty string if none.
+func (p Project) Env() string {
+	return p.env
+}
+
+// MarshalYAML for yaml.Marshaler interface. Values expanded from
+// references when the project was loaded are saved as references.
+func (p Project) MarshalYAML() (interface{}, error) {
+	type project Project
+
+	if len(p.refs) == 0 {
+		return project(p), nil
+	}
+
+	data, err := yaml.Marshal(project(p))
+	if err != nil {
+		return nil, err
+	}
+
+	tree := yaml.MapSlice{}
+	err = yaml.Unmarshal(data, &tree)
+	if err != nil {
+		return nil, err
+	}
+
+	return restoreRefs(tree, p.refs), nil
+}
@@ -40,22 +40,29 @@ import (
 type RunnerCfg struct {
 	Project       Project
 	DriverManager *driver.Manager
-	TunnelManager tunnel.Manager
+	TunnelManager *tunnel.Manager
 	Quiet         bool // Fast mode (no file log / sampled status)
-	DryRun        bool
+	DryRun        bool // Preview records and queries without writing
 	Verbose       bool
 	NoTime        bool   // Disable timestamps and duration for deterministic output
-	Limit         int    // Limit the number of records to process
+	Limit         int    // Limit the number of source records read, DryRunRecords for a dry run by default
+	Retries       int    // Number of times to retry a failed destination write
 	Path          string // relative path to config
 	LocalDbPath   string // output path
+	LookupCache   int    // Number of query() results to cache per run (0 disables)
+	NoHistory     bool   // Do not record runs in the project run history
+	Version       string // dmk version recorded in the run history
 	Logger        *zap.Logger
 }
 
 // see NewRunner
 type runner struct {
-	Cfg     RunnerCfg
-	Log     *zap.Logger
-	drivers map[string]driver.Driver // store configured drivers
+	Cfg          RunnerCfg
+	Log          *zap.Logger
+	drivers      map[string]driver.Driver   // store configured drivers
+	runIDs       []uint64                   // history ids of the running migration and its parents
+	depth        int                        // 1 for the migration being run, more for script run() migrations
+	dryRunValues map[string]*dryRunValueMap // value map writes of a dry run by migration
 }
 
 var localDbs map[string]*bolt.DB // local bold databases for value mapping
@@ -94,12 +101,7 @@ func NewRunner(cfg RunnerCfg) *runner {
 // getLocalDb gets the database
 func (r *runner) getLocalDb(migration string) (*bolt.DB, error) {
 	// one database per migration (to avoid dealing with multiple writers)
-	basePath := r.Cfg.Path
-
-	if r.Cfg.LocalDbPath != "" {
-		basePath = r.Cfg.LocalDbPath
-	}
-	dbFile := basePath + r.Cfg.Project.Component.MachineName + "-" + migration + ".db"
+	dbFile := r.localDbFile(migration)
 
 	if db, ok := localDbs[dbFile]; ok {
 		return db, nil
@@ -118,6 +120,33 @@ func (r *runner) getLocalDb(migration string) (*bolt.DB, error) {
 	return db, nil
 }
 
+// localDbFile returns the local database file of a migration
+func (r *runner) localDbFile(migration string) string {
+	basePath := r.Cfg.Path
+
+	if r.Cfg.LocalDbPath != "" {
+		basePath = r.Cfg.LocalDbPath
+	}
+
+	return LocalDbFile(basePath, r.Cfg.Project.Component.MachineName, migration)
+}
+
+// CloseLocalDbs releases the collections of collectors and closes
+// all open local databases. Collectors configured after it open their
+// local database again.
+func CloseLocalDbs() error {
+	err := driver.ReleaseCollections()
+
+	for dbFile, db := range localDbs {
+		if cerr := db.Close(); cerr != nil {
+			err = cerr
+		}
+		delete(localDbs, dbFile)
+	}
+
+	return err
+}
+
 // configureDriver configures a driver for the migration and database. The configured
 // drivers is stored in the event it needs to be re-used in a sub migration.
 func (r *runner) configureDriver(migration string, db cfg.Database) (driver.Driver, error) {
@@ -139,8 +168,19 @@ func (r *runner) configureDriver(migration string, db cfg.Database) (driver.Driv
 		return nil, err
 	}
 
+	// drivers that keep data in a local database
+	if ldu, ok := d.(driver.LocalDbUser); ok {
+		ldu.SetLocalDb(r.getLocalDb)
+	}
+
+	// tunnel variables are replaced with the bound local endpoint
+	config, err := expandConfig(db.Configuration, r.tunnelVars(db))
+	if err != nil {
+		return nil, fmt.Errorf("database %s: %s", db.Component.MachineName, err)
+	}
+
 	// configure the driver
-	err = d.Configure(db.Configuration)
+	err = d.Configure(config)
 	if err != nil {
 		return nil, err
 	}
@@ -152,39 +192,102 @@ func (r *runner) configureDriver(migration string, db cfg.Database) (driver.Driv
 	return d, nil
 }
 
+// Database opens the tunnel of a project database, if it has one,
+// and returns its configured driver for use outside a migration.
+func (r *runner) Database(machineName string) (driver.Driver, error) {
+	db, ok := r.Cfg.Project.Databases[machineName]
+	if ok != true {
+		return nil, errors.New("no database found for " + machineName)
+	}
+
+	err := r.tunnel(db)
+	if err != nil {
+		return nil, fmt.Errorf("unable to tunnel for %s: %s", machineName, err)
+	}
+
+	return r.configureDriver("", db)
+}
+
 // tunnel if needed
 func (r *runner) tunnel(database cfg.Database) error {
 	// setup a tunnel if needed
 	if database.Tunnel != "" {
 		if tunnelCfg, ok := r.Cfg.Project.Tunnels[database.Tunnel]; ok {
-			err := r.Cfg.TunnelManager.Tunnel(tunnelCfg)
-			if err != nil {
-				return err
-			}
-
-			// take a breath while our tunnel connects
-			// TODO: detect connection
-			time.Sleep(2 * time.Second)
+			return r.Cfg.TunnelManager.Tunnel(tunnelCfg)
 		}
 
+		return errors.New("no tunnel found for " + database.Tunnel)
 	}
 
 	return nil
 }
 
+// DryRunRecords is the number of records a dry run previews when no
+// limit is set
+const DryRunRecords = 5
+
+// Reasons a run ended before reading every source record
+const (
+	EndedByScript = "end"   // the transformation script called end()
+	EndedByLimit  = "limit" // Limit records were read
+)
+
 // RunResult is returned by the Run method
 type RunResult struct {
-	MachineName       string
-	SourceArgs        []string
-	DestinationDriver *driver.Driver
-	SourceDriver      *driver.Driver
-	Started           time.Time
-	Count             int
-	Duration          time.Duration
+	MachineName       string         `json:"machineName"`
+	SourceArgs        []string       `json:"sourceArgs"`
+	DestinationDriver *driver.Driver `json:"-"`
+	SourceDriver      *driver.Driver `json:"-"`
+	Started           time.Time      `json:"started"`
+	SetupDone         bool           `json:"setupDone"` // records were read, a failure may leave a partial migration
+	Count             int            `json:"count"`     // records processed, skipped records are not counted
+	Read              int            `json:"read"`      // records read from the source
+	Written           int            `json:"written"`   // records sent to the destination
+	Skipped           int            `json:"skipped"`   // records the script skipped
+	Failed            int            `json:"failed"`    // records that failed to render or write
+	Retried           int            `json:"retried"`   // records written again after a failed write
+	Ended             string         `json:"ended"`     // EndedByScript or EndedByLimit if the run ended early
+
+	// durations in nanoseconds, zero with NoTime
+	SetupDuration    time.Duration `json:"setupDuration"`    // tunnels, drivers and the source query
+	ReadDuration     time.Duration `json:"readDuration"`     // waiting for source records
+	ScriptDuration   time.Duration `json:"scriptDuration"`   // transformation script
+	TemplateDuration time.Duration `json:"templateDuration"` // destination query template
+	WriteDuration    time.Duration `json:"writeDuration"`    // destination writes
+	Duration         time.Duration `json:"duration"`         // total
+
+	Previews []Preview `json:"previews,omitempty"` // records of a dry run
+}
+
+// Preview is a record of a dry run: the source record, the record
+// after the transformation script and the rendered destination query
+// with its args. Nothing is sent to the destination.
+type Preview struct {
+	Number  int           `json:"number"`
+	Source  driver.Record `json:"source"`
+	Record  driver.Record `json:"record,omitempty"`
+	Query   string        `json:"query,omitempty"`
+	Args    []string      `json:"args,omitempty"`
+	Skipped bool          `json:"skipped,omitempty"` // the script called skip()
+	Ended   bool          `json:"ended,omitempty"`   // the script called end()
 }
 
-// Run runs a migration
+// Run runs a migration and records it in the project run history
 func (r *runner) Run(machineName string, sourceArgs []string) (*RunResult, error) {
+	r.depth++
+	defer func() { r.depth-- }()
+
+	entry := r.startHistory(machineName, sourceArgs)
+
+	runResult, err := r.run(machineName, sourceArgs)
+
+	r.finishHistory(entry, runResult, err)
+
+	return runResult, err
+}
+
+// run runs a migration
+func (r *runner) run(machineName string, sourceArgs []string) (*RunResult, error) {
 	migrationStart := time.Now()
 
 	runResult := &RunResult{
@@ -193,6 +296,9 @@ func (r *runner) Run(machineName string, sourceArgs []string) (*RunResult, error
 		Started:     migrationStart,
 	}
 
+	// the duration of failed runs is set on return
+	defer r.setDurations(runResult, migrationStart)
+
 	r.Log.Info("Running Migration",
 		zap.String("Type", "Setup"),
 		zap.String("MachineName", machineName),
@@ -217,7 +323,7 @@ func (r *runner) Run(machineName string, sourceArgs []string) (*RunResult, error
 	err := r.tunnel(sourceDb)
 	if err != nil {
 		r.Log.Error("TunnelError", zap.String("Type", "Setup"), zap.Error(err))
-		return runResult, errors.New("unable to tunnel for " + sourceDb.Component.Name)
+		return runResult, fmt.Errorf("unable to tunnel for %s: %s", migration.SourceDb, err)
 	}
 
 	// get a driver for the source of migration
@@ -260,6 +366,14 @@ func (r *runner) Run(machineName string, sourceArgs []string) (*RunResult, error
 		return runResult, err
 	}
 
+	// release the source if the run ends before reading all records
+	sourceRead := false
+	defer func() {
+		if sourceRead == false {
+			driver.StopOut(sourceDriver, sourceRecordChan)
+		}
+	}()
+
 	r.Log.Info("Migration DestinationDb",
 		zap.String("Type", "Setup"),
 		zap.String("MachineName", migration.DestinationDb),
@@ -276,6 +390,12 @@ func (r *runner) Run(machineName string, sourceArgs []string) (*RunResult, error
 		zap.String("Type", "Setup"),
 		zap.String("MachineName", destinationDb.Driver))
 
+	err = r.tunnel(destinationDb)
+	if err != nil {
+		r.Log.Error("TunnelError", zap.String("Type", "Setup"), zap.Error(err))
+		return runResult, fmt.Errorf("unable to tunnel for %s: %s", migration.DestinationDb, err)
+	}
+
 	destinationDriver, err := r.configureDriver(machineName, destinationDb)
 	if err != nil {
 		return runResult, err
@@ -290,14 +410,18 @@ func (r *runner) Run(machineName string, sourceArgs []string) (*RunResult, error
 	// see https://github.com/mcuadros/go-candyjs
 	ctx := candyjs.NewContext()
 	defer ctx.DestroyHeap()
-	r.addScriptFunctions(*ctx, machineName)
+	r.addScriptFunctions(*ctx, machineName, newLookupCache(r.Cfg.LookupCache))
 
 	queryTemplate, err := template.New("query").Funcs(sprig.TxtFuncMap()).Parse(migration.DestinationQuery)
 	if err != nil {
-		panic(err)
+		r.Log.Error("DestinationQueryError",
+			zap.String("Type", "Setup"), zap.Error(err))
+		return runResult, fmt.Errorf("destination query of %s: %s", machineName, err)
 	}
 
-	setupDuration := time.Now().Sub(migrationStart)
+	runResult.SetupDuration = time.Now().Sub(migrationStart)
+
+	setupDuration := runResult.SetupDuration
 	if r.Cfg.NoTime {
 		setupDuration = 0
 	}
@@ -309,15 +433,60 @@ func (r *runner) Run(machineName string, sourceArgs []string) (*RunResult, error
 		zap.Duration("SetupDuration", setupDuration),
 	)
 
-	count := 0
+	runResult.SetupDone = true
+
+	// the limit applies to the migration being run, migrations run by
+	// its script with run() read all of their records
+	limit := 0
+	if r.depth == 1 {
+		limit = r.Cfg.Limit
+		if r.Cfg.DryRun && limit == 0 {
+			limit = DryRunRecords
+		}
+	}
+
+	// a dry run previews records instead of writing them, except for
+	// script run() migrations into collectors that the previewed
+	// migration reads from
+	preview := r.Cfg.DryRun
+	if _, ok := destinationDriver.(*driver.Collector); ok && r.depth > 1 {
+		preview = false
+	}
+
+	// previews are kept for the migration being run
+	keepPreview := preview && r.depth == 1
 
-	// iterate over the sourceRecordChan for driver.Record objects
-	for record := range sourceRecordChan {
-		count += 1
+	// read driver.Record objects from the sourceRecordChan
+	for {
+		readStart := time.Now()
+		record, ok := <-sourceRecordChan
+		runResult.ReadDuration += time.Since(readStart)
+		if ok == false {
+			sourceRead = true
+			err = driver.OutError(sourceDriver)
+			if err != nil {
+				r.Log.Error("SourceError",
+					zap.String("MachineName", machineName), zap.Error(err))
+				return runResult, err
+			}
+			break
+		}
+
+		runResult.Read++
+		count := runResult.Read
 		recordStart := time.Now()
 
 		args := make([]string, 0)
 
+		// keep the source record of a dry run, the script may change it
+		var source driver.Record
+		if keepPreview {
+			source = make(driver.Record, len(record))
+			for k, v := range record {
+				source[k] = v
+			}
+		}
+
 		// modify r, driver.Record
 		if script != "" {
 			skipRecord := false
@@ -343,36 +512,73 @@ func (r *runner) Run(machineName string, sourceArgs []string) (*RunResult, error
 				endMigration = true
 			})
 
+			scriptStart := time.Now()
 			ctx.EvalString(script)
+			runResult.ScriptDuration += time.Since(scriptStart)
 
 			// If the transformation script wants us to skip this record
 			if skipRecord {
+				runResult.Skipped++
+				if keepPreview {
+					runResult.Previews = append(runResult.Previews, Preview{Number: count, Source: source, Skipped: true})
+				}
+				// skipped records count toward the limit of records read
+				if limit > 0 && limit <= count {
+					runResult.Ended = EndedByLimit
+					break
+				}
 				continue
 			}
 
-			// If the transformation script wants us to skip this record
+			// If the transformation script wants to end the migration
 			if endMigration {
-				return runResult, nil
+				if keepPreview {
+					runResult.Previews = append(runResult.Previews, Preview{Number: count, Source: source, Ended: true})
+				}
+				runResult.Ended = EndedByScript
+				break
 			}
 		}
 
-		if r.Cfg.DryRun {
-			continue
-		}
+		runResult.Count++
 
+		templateStart := time.Now()
 		var query bytes.Buffer
 		err = queryTemplate.Execute(&query, record)
+		runResult.TemplateDuration += time.Since(templateStart)
 		if err != nil {
+			runResult.Failed++
 			return runResult, err
 		}
 
+		if preview {
+			if keepPreview {
+				runResult.Previews = append(runResult.Previews, Preview{
+					Number: count,
+					Source: source,
+					Record: record,
+					Query:  strings.Trim(query.String(), "\n"),
+					Args:   args,
+				})
+			}
+			if limit > 0 && limit <= count {
+				runResult.Ended = EndedByLimit
+				break
+			}
+			continue
+		}
+
+		writeStart := time.Now()
+		err = r.write(destinationDriver, runResult, query.String(), args, record)
+		runResult.WriteDuration += time.Since(writeStart)
+
 		recDuration := time.Now().Sub(recordStart)
 		if r.Cfg.NoTime {
 			recDuration = 0
 		}
 
-		err = destinationDriver.In(query.String(), args, record)
 		if err != nil {
+			runResult.Failed++
 			r.Log.Error("MigrationError",
 				zap.Error(err),
 				zap.Int("Count", count),
@@ -385,6 +591,8 @@ func (r *runner) Run(machineName string, sourceArgs []string) (*RunResult, error
 			return runResult, err
 		}
 
+		runResult.Written++
+
 		r.Log.Debug("Status",
 			zap.String("Type", "MigrationStatus"),
 			zap.Int("Count", count),
@@ -395,7 +603,7 @@ func (r *runner) Run(machineName string, sourceArgs []string) (*RunResult, error
 			zap.Duration("Duration", recDuration),
 		)
 
-		if r.Cfg.Limit > 0 && r.Cfg.Limit <= count {
+		if limit > 0 && limit <= count {
 			r.Log.Debug("Stopping at specified limit.",
 				zap.String("Type", "Done"),
 				zap.Int("Count", count),
@@ -405,35 +613,84 @@ func (r *runner) Run(machineName string, sourceArgs []string) (*RunResult, error
 				zap.String("MachineName", machineName),
 				zap.Duration("Duration", recDuration),
 			)
+			runResult.Ended = EndedByLimit
 			break
 		}
 
 	}
 
-	destinationDriver.Done()
-
-	t := time.Now()
-	elapsed := t.Sub(migrationStart)
-	processingDuration := elapsed - setupDuration
-
-	if r.Cfg.NoTime {
-		setupDuration, elapsed, processingDuration = 0, 0, 0
+	// a preview sends nothing to the destination
+	if preview == false {
+		err = destinationDriver.Done()
+		if err != nil {
+			r.Log.Error("DestinationDoneError",
+				zap.String("MachineName", machineName), zap.Error(err))
+			return runResult, err
+		}
 	}
 
+	r.setDurations(runResult, migrationStart)
+
 	r.Log.Info("Done with migration.",
 		zap.String("MachineName", migration.Component.MachineName),
 		zap.String("Type", "Done"),
-		zap.Duration("SetupDuration", setupDuration),
-		zap.Duration("ProcessingDuration", processingDuration),
-		zap.Duration("TotalDuration", elapsed),
-		zap.Int("TotalProcessed", count),
+		zap.Duration("SetupDuration", runResult.SetupDuration),
+		zap.Duration("ProcessingDuration", runResult.Duration-runResult.SetupDuration),
+		zap.Duration("TotalDuration", runResult.Duration),
+		zap.Int("TotalProcessed", runResult.Count),
+		zap.Int("Read", runResult.Read),
+		zap.Int("Written", runResult.Written),
+		zap.Int("Skipped", runResult.Skipped),
+		zap.Int("Failed", runResult.Failed),
+		zap.Int("Retried", runResult.Retried),
+		zap.Duration("ReadDuration", runResult.ReadDuration),
+		zap.Duration("ScriptDuration", runResult.ScriptDuration),
+		zap.Duration("TemplateDuration", runResult.TemplateDuration),
+		zap.Duration("WriteDuration", runResult.WriteDuration),
+		zap.String("Ended", runResult.Ended),
 	)
 
-	runResult.Duration = elapsed
-
 	return runResult, nil
 }
 
+// write sends a record to the destination, retrying a failed write
+// up to Retries times
+func (r *runner) write(d driver.Driver, runResult *RunResult, query string, args []string, record driver.Record) error {
+	err := d.In(query, args, record)
+
+	for retry := 1; err != nil && retry <= r.Cfg.Retries; retry++ {
+		if retry == 1 {
+			runResult.Retried++
+		}
+
+		r.Log.Warn("Retrying destination write.",
+			zap.String("Type", "MigrationRetry"),
+			zap.String("MachineName", runResult.MachineName),
+			zap.Int("Retry", retry),
+			zap.Error(err),
+		)
+
+		err = d.In(query, args, record)
+	}
+
+	return err
+}
+
+// setDurations sets the total duration of a run, durations are zero
+// with NoTime for deterministic output
+func (r *runner) setDurations(runResult *RunResult, migrationStart time.Time) {
+	runResult.Duration = time.Now().Sub(migrationStart)
+
+	if r.Cfg.NoTime {
+		runResult.SetupDuration = 0
+		runResult.ReadDuration = 0
+		runResult.ScriptDuration = 0
+		runResult.TemplateDuration = 0
+		runResult.WriteDuration = 0
+		runResult.Duration = 0
+	}
+}
+
 func (r *runner) HttpJsonPost(url, json string) {
 	var netTransport = &http.Transport{
 		Dial: (&net.Dialer{
@@ -467,8 +724,17 @@ func (r *runner) HttpJsonPost(url, json string) {
 
 }
 
+// dryRunHttpJsonPost logs the post a script would send
+func (r *runner) dryRunHttpJsonPost(url, json string) {
+	r.Log.Info("Dry run, not posting.",
+		zap.String("Type", "HttpJsonPostStatus"),
+		zap.String("Url", url),
+		zap.String("Json", json),
+	)
+}
+
 // addScriptFunctions add utility functions to script context
-func (r *runner) addScriptFunctions(ctx candyjs.Context, machineName string) {
+func (r *runner) addScriptFunctions(ctx candyjs.Context, machineName string, cache *lookupCache) {
 
 	// memory storage
 	storage := make(map[string]interface{})
@@ -477,10 +743,22 @@ func (r *runner) addScriptFunctions(ctx candyjs.Context, machineName string) {
 	// migrations that migrate to a collector
 	ctx.PushGlobalGoFunction("run", r.scriptRunner)
 
-	ctx.PushGlobalGoFunction("httpJsonPost", r.HttpJsonPost)
+	// a dry run logs posts instead of sending them
+	if r.Cfg.DryRun {
+		ctx.PushGlobalGoFunction("httpJsonPost", r.dryRunHttpJsonPost)
+	} else {
+		ctx.PushGlobalGoFunction("httpJsonPost", r.HttpJsonPost)
+	}
+
+	// lookup queries against configured databases
+	ctx.PushGlobalGoFunction("query", r.scriptLookup(machineName, cache))
+
+	// keyed lookups into indexed collectors
+	ctx.PushGlobalGoFunction("collectorGet", r.scriptCollectorGet(machineName))
 
 	// persistent storage for value maps
 	ctx.PushGlobalGoFunction("persistVal", r.persistVal)
+	r.addValueMapFunctions(ctx, machineName)
 
 	ctx.PushGlobalGoFunction("getMigration", func() string {
 		return machineName
@@ -516,49 +794,44 @@ func (r *runner) addScriptFunctions(ctx candyjs.Context, machineName string) {
 	})
 }
 
-// persistVal gets or stores a fallback value
-func (r *runner) persistVal(migration string, k string, fallback string) string {
-	db, err := r.getLocalDb(migration)
-	if err != nil {
-		fmt.Printf("LOCAL DB ERROR: %s", err.Error())
-		return ""
-	}
-	var retVal []byte
-
-	bucket := "persistVal"
-
-	found := false
-
-	// ensure that we have a bucket
-	ensureBucket(db, bucket)
-
-	db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucket))
-		v := b.Get([]byte(k))
-
-		if v != nil {
-			retVal = make([]byte, len(v))
-			copy(retVal, v)
-			found = true
-			return nil
+// scriptCollectorGet returns the collectorGet function for a script
+// context. Items are looked up by the indexField value of the
+// collector database.
+func (r *runner) scriptCollectorGet(machineName string) func(string, interface{}) []driver.ResultCollectionItem {
+	return func(dbMachineName string, key interface{}) []driver.ResultCollectionItem {
+		items, err := r.collectorGet(dbMachineName, fmt.Sprintf("%v", key))
+		if err != nil {
+			r.Log.Error("ScriptCollectorGetError",
+				zap.String("Type", "ScriptCollectorGet"),
+				zap.String("MachineName", machineName),
+				zap.String("Database", dbMachineName),
+				zap.Error(err),
+			)
+			return []driver.ResultCollectionItem{}
 		}
 
-		return nil
-	})
+		return items
+	}
+}
 
-	if found == true {
-		return string(retVal)
+// collectorGet looks up items in a collector database by key
+func (r *runner) collectorGet(dbMachineName string, key string) ([]driver.ResultCollectionItem, error) {
+	db, ok := r.Cfg.Project.Databases[dbMachineName]
+	if ok != true {
+		return nil, errors.New("no database found for " + dbMachineName)
 	}
 
-	go func() {
-		db.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte(bucket))
-			err := b.Put([]byte(k), []byte(fallback))
-			return err
-		})
-	}()
+	d, err := r.configureDriver("lookup", db)
+	if err != nil {
+		return nil, err
+	}
 
-	return fallback
+	cd, ok := d.(*driver.Collector)
+	if ok != true {
+		return nil, errors.New(dbMachineName + " is not a collector")
+	}
+
+	return cd.Lookup(key)
 }
 
 // scriptRunner returns run function for script context
@@ -568,6 +841,11 @@ func (r *runner) scriptRunner(machineNameFromScript string, argsFromScript []str
 		fmt.Printf("ERROR: %s\n", err.Error())
 	}
 
+	// a migration that failed during setup has no destination
+	if runResult == nil || runResult.DestinationDriver == nil {
+		return []driver.ResultCollectionItem{}
+	}
+
 	dd := *runResult.DestinationDriver
 
 	if cdd, ok := dd.(*driver.Collector); ok {
@@ -584,16 +862,3 @@ func (r *runner) scriptRunner(machineNameFromScript string, argsFromScript []str
 
 	return []driver.ResultCollectionItem{}
 }
-
-// ensureBucket makes a bucket if one does not exist
-func ensureBucket(db *bolt.DB, bucket string) error {
-	err := db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
-		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
-		}
-		return nil
-	})
-
-	return err
-}
@@ -0,0 +1,238 @@
+package migrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+	"github.com/mcuadros/go-candyjs"
+	"github.com/txn2/dmk/cfg"
+	"github.com/txn2/dmk/driver"
+	"github.com/txn2/dmk/tunnel"
+)
+
+// Issue levels
+const (
+	IssueError   = "error"
+	IssueWarning = "warning"
+)
+
+// Issue is a problem found when validating a project
+type Issue struct {
+	Level     string // IssueError or IssueWarning
+	Component string // ex: migration example_csv_to_cassandra
+	Message   string
+}
+
+// scriptRefRx matches script calls referencing migrations with
+// run("name", ...) and databases with query("name", ...) or
+// collectorGet("name", ...)
+var scriptRefRx = regexp.MustCompile(`\b(run|query|collectorGet)\s*\(\s*["']([^"']+)["']`)
+
+// validator collects issues for a project
+type validator struct {
+	project Project
+	dm      *driver.Manager
+	issues  []Issue
+	usedDbs map[string]bool
+}
+
+// Validate checks a project for problems that would otherwise only
+// show up when a migration runs: references to missing components,
+// driver configuration keys, destination query templates, script
+// syntax, source query argument counts, tunnel endpoints and
+// authentication methods and unused components.
+func Validate(project Project, dm *driver.Manager) []Issue {
+	v := &validator{
+		project: project,
+		dm:      dm,
+		issues:  make([]Issue, 0),
+		usedDbs: make(map[string]bool),
+	}
+
+	for _, k := range project.MigrationNames() {
+		v.migration(k)
+	}
+
+	for _, k := range project.DatabaseNames() {
+		v.database(k)
+	}
+
+	usedTunnels := make(map[string]bool)
+	for _, db := range project.Databases {
+		usedTunnels[db.Tunnel] = true
+	}
+
+	for _, k := range project.TunnelNames() {
+		v.tunnel(k)
+		if usedTunnels[k] == false {
+			v.add(IssueWarning, "tunnel "+k, "not used by any database")
+		}
+	}
+
+	return v.issues
+}
+
+// add an issue
+func (v *validator) add(level string, component string, format string, a ...interface{}) {
+	v.issues = append(v.issues, Issue{
+		Level:     level,
+		Component: component,
+		Message:   fmt.Sprintf(format, a...),
+	})
+}
+
+// database checks a database
+func (v *validator) database(machineName string) {
+	db := v.project.Databases[machineName]
+	component := "database " + machineName
+
+	if v.usedDbs[machineName] == false {
+		v.add(IssueWarning, component, "not used by any migration or script")
+	}
+
+	if db.Tunnel != "" {
+		if _, ok := v.project.Tunnels[db.Tunnel]; ok == false {
+			v.add(IssueError, component, "tunnel %s does not exist", db.Tunnel)
+		}
+	}
+
+	d, err := v.dm.GetNewDriver(db.Driver)
+	if err != nil {
+		v.add(IssueError, component, "driver %s does not exist", db.Driver)
+		return
+	}
+
+	for _, err := range d.ConfigSchema().Validate(db.Configuration) {
+		v.add(IssueError, component, "%s", err)
+	}
+}
+
+// tunnel checks a tunnel
+func (v *validator) tunnel(machineName string) {
+	t := v.project.Tunnels[machineName]
+	component := "tunnel " + machineName
+
+	type roleEndpoint struct {
+		role     string
+		endpoint cfg.Endpoint
+	}
+
+	endpoints := []roleEndpoint{{"server", t.Server}, {"remote", t.Remote}}
+	auths := []cfg.TunnelAuth{t.TunnelAuth}
+
+	for i, jumpHost := range t.JumpHosts {
+		endpoints = append(endpoints, roleEndpoint{fmt.Sprintf("jump host %d server", i+1), jumpHost.Server})
+		auths = append(auths, jumpHost.TunnelAuth)
+	}
+
+	for _, ep := range endpoints {
+		if ep.endpoint.Host == "" || ep.endpoint.Port <= 0 {
+			v.add(IssueError, component, "%s endpoint needs a host and port", ep.role)
+		}
+	}
+
+	for _, auth := range auths {
+		for _, method := range auth.Methods {
+			if authMethod(method) == false {
+				v.add(IssueError, component, "unknown authentication method %s, use %s", method, strings.Join(tunnel.DefaultAuthMethods, ", "))
+			}
+		}
+	}
+}
+
+// authMethod returns true if method is a tunnel authentication method
+func authMethod(method string) bool {
+	for _, m := range tunnel.DefaultAuthMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// migration checks a migration
+func (v *validator) migration(machineName string) {
+	m := v.project.Migrations[machineName]
+	component := "migration " + machineName
+
+	sourceDriver := v.migrationDb(component, "source", m.SourceDb)
+	destinationDriver := v.migrationDb(component, "destination", m.DestinationDb)
+
+	if sourceDriver != nil && sourceDriver.HasOutQuery() {
+		if n := sourceDriver.ArgCount(m.SourceQuery); n != m.SourceQueryNArgs {
+			v.add(IssueError, component, "source query has %d arguments but sourceQueryNArgs is %d", n, m.SourceQueryNArgs)
+		}
+	}
+
+	if _, err := template.New("query").Funcs(sprig.TxtFuncMap()).Parse(m.DestinationQuery); err != nil {
+		v.add(IssueError, component, "destination query template: %s", err)
+	}
+
+	if destinationDriver != nil && m.TransformationScript == "" {
+		if n := destinationDriver.ArgCount(m.DestinationQuery); n > 0 {
+			v.add(IssueError, component, "destination query has %d arguments but there is no transformation script to send them", n)
+		}
+	}
+
+	if m.TransformationScript == "" {
+		return
+	}
+
+	if err := scriptSyntax(m.TransformationScript); err != nil {
+		v.add(IssueError, component, "transformation script: %s", err)
+	}
+
+	for _, ref := range scriptRefRx.FindAllStringSubmatch(m.TransformationScript, -1) {
+		if ref[1] == "run" {
+			if _, ok := v.project.Migrations[ref[2]]; ok == false {
+				v.add(IssueError, component, "script runs migration %s which does not exist", ref[2])
+			}
+			continue
+		}
+
+		v.usedDbs[ref[2]] = true
+		if _, ok := v.project.Databases[ref[2]]; ok == false {
+			v.add(IssueError, component, "script calls %s on database %s which does not exist", ref[1], ref[2])
+		}
+	}
+}
+
+// migrationDb checks a migration database reference and returns an
+// unconfigured driver for it, nil if there is none.
+func (v *validator) migrationDb(component string, role string, machineName string) driver.Driver {
+	if machineName == "" {
+		v.add(IssueError, component, "no %s database", role)
+		return nil
+	}
+
+	v.usedDbs[machineName] = true
+
+	db, ok := v.project.Databases[machineName]
+	if ok == false {
+		v.add(IssueError, component, "%s database %s does not exist", role, machineName)
+		return nil
+	}
+
+	d, err := v.dm.GetNewDriver(db.Driver)
+	if err != nil {
+		return nil
+	}
+
+	return d
+}
+
+// scriptSyntax compiles a script without running it
+func scriptSyntax(script string) error {
+	ctx := candyjs.NewContext()
+	defer ctx.DestroyHeap()
+
+	err := ctx.PcompileLstring(0, script, len(script))
+	if err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(err.Error()))
+	}
+
+	return nil
+}
@@ -0,0 +1,430 @@
+package migrate
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mcuadros/go-candyjs"
+	"go.uber.org/zap"
+)
+
+// ValueMap is a collection of named key/value maps stored in a
+// local bolt database. Each named map is a bolt bucket.
+type ValueMap struct {
+	db *bolt.DB
+}
+
+// NewValueMap returns a ValueMap backed by a bolt database
+func NewValueMap(db *bolt.DB) *ValueMap {
+	return &ValueMap{db: db}
+}
+
+// Get returns the value for key in the named map. The second
+// return value is false if the key (or map) does not exist.
+func (vm *ValueMap) Get(name string, key string) (string, bool, error) {
+	var val string
+	found := false
+
+	err := vm.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+
+		if v := b.Get([]byte(key)); v != nil {
+			val = string(v)
+			found = true
+		}
+
+		return nil
+	})
+
+	return val, found, err
+}
+
+// Set stores a value for key in the named map, creating the
+// map if it does not exist.
+func (vm *ValueMap) Set(name string, key string, value string) error {
+	return vm.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(key), []byte(value))
+	})
+}
+
+// Delete removes key from the named map.
+func (vm *ValueMap) Delete(name string, key string) error {
+	return vm.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+
+		return b.Delete([]byte(key))
+	})
+}
+
+// GetOrSet returns the value for key in the named map. If the
+// key does not exist the fallback is stored and returned. The
+// lookup and the store happen in a single transaction, so
+// concurrent callers always receive the same value for a key.
+func (vm *ValueMap) GetOrSet(name string, key string, fallback string) (string, error) {
+	val := fallback
+
+	err := vm.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+
+		if v := b.Get([]byte(key)); v != nil {
+			val = string(v)
+			return nil
+		}
+
+		return b.Put([]byte(key), []byte(fallback))
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return val, nil
+}
+
+// Maps returns the names of all maps
+func (vm *ValueMap) Maps() ([]string, error) {
+	names := make([]string, 0)
+
+	err := vm.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, string(name))
+			return nil
+		})
+	})
+
+	return names, err
+}
+
+// Count returns the number of keys in the named map
+func (vm *ValueMap) Count(name string) (int, error) {
+	count := 0
+
+	err := vm.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+
+		count = b.Stats().KeyN
+		return nil
+	})
+
+	return count, err
+}
+
+// ForEach calls fn for every key and value in the named map
+// in key order. Iteration stops if fn returns an error.
+func (vm *ValueMap) ForEach(name string, fn func(k string, v string) error) error {
+	return vm.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			return errors.New("no such map: " + name)
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			return fn(string(k), string(v))
+		})
+	})
+}
+
+// Import stores a set of key/value pairs in the named map in a
+// single transaction.
+func (vm *ValueMap) Import(name string, values map[string]string) error {
+	return vm.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+
+		for k, v := range values {
+			err = b.Put([]byte(k), []byte(v))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Clear removes the named map and all of its keys.
+func (vm *ValueMap) Clear(name string) error {
+	return vm.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(name)) == nil {
+			return errors.New("no such map: " + name)
+		}
+
+		return tx.DeleteBucket([]byte(name))
+	})
+}
+
+// Close closes the underlying database
+func (vm *ValueMap) Close() error {
+	return vm.db.Close()
+}
+
+// LocalDbFile returns the path of the local database for a
+// project migration.
+func LocalDbFile(basePath string, project string, migration string) string {
+	return basePath + project + "-" + migration + ".db"
+}
+
+// OpenValueMap opens the ValueMap stored in a local database file,
+// creating the file if it does not exist.
+func OpenValueMap(dbFile string) (*ValueMap, error) {
+	db, err := bolt.Open(dbFile, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewValueMap(db), nil
+}
+
+// valueStore is a ValueMap or the in memory value maps of a dry run
+type valueStore interface {
+	Get(name string, key string) (string, bool, error)
+	Set(name string, key string, value string) error
+	Delete(name string, key string) error
+	GetOrSet(name string, key string, fallback string) (string, error)
+}
+
+// dryRunValueMap keeps the value map writes of a dry run in memory.
+// Keys not written in the dry run are read from the stored maps.
+type dryRunValueMap struct {
+	stored *ValueMap                     // nil if the migration has no local database
+	values map[string]map[string]*string // a nil value is a deleted key
+	mux    sync.Mutex
+}
+
+// Get for valueStore
+func (dm *dryRunValueMap) Get(name string, key string) (string, bool, error) {
+	dm.mux.Lock()
+	defer dm.mux.Unlock()
+
+	return dm.get(name, key)
+}
+
+// get returns the value written in the dry run or the stored value
+func (dm *dryRunValueMap) get(name string, key string) (string, bool, error) {
+	if v, ok := dm.values[name][key]; ok {
+		if v == nil {
+			return "", false, nil
+		}
+		return *v, true, nil
+	}
+
+	if dm.stored == nil {
+		return "", false, nil
+	}
+
+	return dm.stored.Get(name, key)
+}
+
+// put records a write of the dry run
+func (dm *dryRunValueMap) put(name string, key string, value *string) {
+	if dm.values[name] == nil {
+		dm.values[name] = make(map[string]*string)
+	}
+	dm.values[name][key] = value
+}
+
+// Set for valueStore
+func (dm *dryRunValueMap) Set(name string, key string, value string) error {
+	dm.mux.Lock()
+	defer dm.mux.Unlock()
+
+	dm.put(name, key, &value)
+	return nil
+}
+
+// Delete for valueStore
+func (dm *dryRunValueMap) Delete(name string, key string) error {
+	dm.mux.Lock()
+	defer dm.mux.Unlock()
+
+	dm.put(name, key, nil)
+	return nil
+}
+
+// GetOrSet for valueStore
+func (dm *dryRunValueMap) GetOrSet(name string, key string, fallback string) (string, error) {
+	dm.mux.Lock()
+	defer dm.mux.Unlock()
+
+	v, found, err := dm.get(name, key)
+	if err != nil || found {
+		return v, err
+	}
+
+	dm.put(name, key, &fallback)
+	return fallback, nil
+}
+
+// valueMap returns the ValueMap for a migration's local database, a
+// dry run gets maps that are not written to the local database
+func (r *runner) valueMap(migration string) (valueStore, error) {
+	if r.Cfg.DryRun {
+		return r.dryRunValueMap(migration)
+	}
+
+	db, err := r.getLocalDb(migration)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewValueMap(db), nil
+}
+
+// dryRunValueMap returns the dry run value maps of a migration, the
+// local database is only opened if it exists
+func (r *runner) dryRunValueMap(migration string) (*dryRunValueMap, error) {
+	if dm, ok := r.dryRunValues[migration]; ok {
+		return dm, nil
+	}
+
+	dm := &dryRunValueMap{values: make(map[string]map[string]*string)}
+
+	if _, err := os.Stat(r.localDbFile(migration)); err == nil {
+		db, err := r.getLocalDb(migration)
+		if err != nil {
+			return nil, err
+		}
+		dm.stored = NewValueMap(db)
+	}
+
+	if r.dryRunValues == nil {
+		r.dryRunValues = make(map[string]*dryRunValueMap)
+	}
+	r.dryRunValues[migration] = dm
+
+	return dm, nil
+}
+
+// persistVal gets or stores a fallback value
+func (r *runner) persistVal(migration string, k string, fallback string) string {
+	vm, err := r.valueMap(migration)
+	if err != nil {
+		r.valueMapError(migration, "persistVal", k, err)
+		return ""
+	}
+
+	v, err := vm.GetOrSet("persistVal", k, fallback)
+	if err != nil {
+		r.valueMapError(migration, "persistVal", k, err)
+		return ""
+	}
+
+	return v
+}
+
+// addValueMapFunctions adds named value map functions to a script
+// context. Maps are stored in the local database of the migration.
+func (r *runner) addValueMapFunctions(ctx candyjs.Context, machineName string) {
+
+	// mapGet returns the value of a key or an empty string
+	ctx.PushGlobalGoFunction("mapGet", func(name string, k string) string {
+		vm, err := r.valueMap(machineName)
+		if err != nil {
+			r.valueMapError(machineName, name, k, err)
+			return ""
+		}
+
+		v, _, err := vm.Get(name, k)
+		if err != nil {
+			r.valueMapError(machineName, name, k, err)
+			return ""
+		}
+
+		return v
+	})
+
+	// mapHas returns true if the map contains a key
+	ctx.PushGlobalGoFunction("mapHas", func(name string, k string) bool {
+		vm, err := r.valueMap(machineName)
+		if err != nil {
+			r.valueMapError(machineName, name, k, err)
+			return false
+		}
+
+		_, found, err := vm.Get(name, k)
+		if err != nil {
+			r.valueMapError(machineName, name, k, err)
+			return false
+		}
+
+		return found
+	})
+
+	// mapSet stores a value for a key
+	ctx.PushGlobalGoFunction("mapSet", func(name string, k string, v string) bool {
+		vm, err := r.valueMap(machineName)
+		if err == nil {
+			err = vm.Set(name, k, v)
+		}
+		if err != nil {
+			r.valueMapError(machineName, name, k, err)
+			return false
+		}
+
+		return true
+	})
+
+	// mapDelete removes a key
+	ctx.PushGlobalGoFunction("mapDelete", func(name string, k string) bool {
+		vm, err := r.valueMap(machineName)
+		if err == nil {
+			err = vm.Delete(name, k)
+		}
+		if err != nil {
+			r.valueMapError(machineName, name, k, err)
+			return false
+		}
+
+		return true
+	})
+
+	// mapGetOrSet returns the value of a key, storing the
+	// fallback if the key does not exist
+	ctx.PushGlobalGoFunction("mapGetOrSet", func(name string, k string, fallback string) string {
+		vm, err := r.valueMap(machineName)
+		if err != nil {
+			r.valueMapError(machineName, name, k, err)
+			return ""
+		}
+
+		v, err := vm.GetOrSet(name, k, fallback)
+		if err != nil {
+			r.valueMapError(machineName, name, k, err)
+			return ""
+		}
+
+		return v
+	})
+}
+
+// valueMapError logs value map errors from scripts
+func (r *runner) valueMapError(migration string, name string, key string, err error) {
+	r.Log.Error("ValueMapError",
+		zap.String("Type", "ValueMap"),
+		zap.String("MachineName", migration),
+		zap.String("Map", name),
+		zap.String("Key", key),
+		zap.Error(err),
+	)
+}
@@ -0,0 +1,154 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testValueMap opens a ValueMap in a temporary directory
+func testValueMap(t *testing.T) (*ValueMap, func()) {
+	dir, err := ioutil.TempDir("", "dmk-valmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm, err := OpenValueMap(filepath.Join(dir, "test.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return vm, func() {
+		vm.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// TestValueMapGetOrSet tests a fallback is stored only for keys
+// that do not exist.
+func TestValueMapGetOrSet(t *testing.T) {
+	vm, done := testValueMap(t)
+	defer done()
+
+	if err := vm.Set("ids", "existing", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		mapName  string
+		key      string
+		fallback string
+		want     string
+	}{
+		{"existing key", "ids", "existing", "2", "1"},
+		{"new key", "ids", "new", "3", "3"},
+		{"new key again", "ids", "new", "4", "3"},
+		{"new map", "other", "existing", "5", "5"},
+	}
+
+	for _, tt := range tests {
+		got, err := vm.GetOrSet(tt.mapName, tt.key, tt.fallback)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+
+		stored, found, err := vm.Get(tt.mapName, tt.key)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if found == false || stored != tt.want {
+			t.Errorf("%s: stored %q (found %t), want %q", tt.name, stored, found, tt.want)
+		}
+	}
+}
+
+// TestValueMapImport tests imported values are added to and replace
+// the values of a map.
+func TestValueMapImport(t *testing.T) {
+	vm, done := testValueMap(t)
+	defer done()
+
+	if err := vm.Set("names", "a", "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := vm.Import("names", map[string]string{"a": "new", "b": "added"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key   string
+		want  string
+		found bool
+	}{
+		{"a", "new", true},
+		{"b", "added", true},
+		{"c", "", false},
+	}
+
+	for _, tt := range tests {
+		got, found, err := vm.Get("names", tt.key)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.key, err)
+		}
+		if got != tt.want || found != tt.found {
+			t.Errorf("%s: got %q (found %t), want %q (found %t)", tt.key, got, found, tt.want, tt.found)
+		}
+	}
+
+	count, err := vm.Count("names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("got %d keys, want 2", count)
+	}
+}
+
+// TestValueMapClear tests clearing removes a map and only that map.
+func TestValueMapClear(t *testing.T) {
+	vm, done := testValueMap(t)
+	defer done()
+
+	for _, name := range []string{"keep", "clear"} {
+		if err := vm.Set(name, "k", "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		mapName string
+		wantErr bool
+	}{
+		{"existing map", "clear", false},
+		{"cleared map", "clear", true},
+		{"missing map", "missing", true},
+	}
+
+	for _, tt := range tests {
+		err := vm.Clear(tt.mapName)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %t", tt.name, err, tt.wantErr)
+		}
+	}
+
+	maps, err := vm.Maps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(maps) != 1 || maps[0] != "keep" {
+		t.Errorf("got maps %v, want [keep]", maps)
+	}
+
+	if _, found, _ := vm.Get("clear", "k"); found {
+		t.Errorf("cleared map still has its key")
+	}
+}
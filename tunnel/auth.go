@@ -0,0 +1,157 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/txn2/dmk/cfg"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// DefaultAuthMethods is the order authentication methods are tried
+// when a TunnelAuth does not specify any.
+var DefaultAuthMethods = []string{"agent", "key", "password"}
+
+// AuthMethods returns the ssh authentication methods for a TunnelAuth
+// in the configured order. Unavailable methods are skipped, an error
+// is returned if no method is available. The returned closers release
+// the connections the methods use, the caller closes them when the
+// methods are no longer needed.
+func AuthMethods(tunnelAuth cfg.TunnelAuth) ([]ssh.AuthMethod, []io.Closer, error) {
+	methods := tunnelAuth.Methods
+	if len(methods) == 0 {
+		methods = DefaultAuthMethods
+	}
+
+	authMethods := make([]ssh.AuthMethod, 0)
+	closers := make([]io.Closer, 0)
+	problems := make([]string, 0)
+
+	for _, method := range methods {
+		var am ssh.AuthMethod
+		var err error
+
+		switch method {
+		case "agent":
+			var closer io.Closer
+			am, closer, err = SSHAgent()
+			if err == nil {
+				closers = append(closers, closer)
+			}
+		case "key":
+			am, err = SSHKey(tunnelAuth.KeyFile, tunnelAuth.PassphraseEnv)
+		case "password":
+			am, err = SSHPassword(tunnelAuth.PasswordEnv)
+		default:
+			err = errors.New("unknown method")
+		}
+
+		if err != nil {
+			problems = append(problems, method+": "+err.Error())
+			continue
+		}
+
+		authMethods = append(authMethods, am)
+	}
+
+	if len(authMethods) == 0 {
+		return nil, nil, fmt.Errorf("no ssh authentication method available (%s)", strings.Join(problems, "; "))
+	}
+
+	return authMethods, closers, nil
+}
+
+// closeAll closes the closers returned by AuthMethods
+func closeAll(closers []io.Closer) error {
+	var err error
+	for _, closer := range closers {
+		if cerr := closer.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// SSHAgent uses the exposed ssh agent from SSH_AUTH_SOCK. The agent
+// signs through the returned connection, it is closed when the ssh
+// connections authenticated with the agent are closed.
+func SSHAgent() (ssh.AuthMethod, io.Closer, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, errors.New("SSH_AUTH_SOCK is not set")
+	}
+
+	sshAgent, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ssh.PublicKeysCallback(agent.NewClient(sshAgent).Signers), sshAgent, nil
+}
+
+// SSHKey uses a private key file. If passphraseEnv is not empty the
+// passphrase for an encrypted key is read from that environment
+// variable.
+func SSHKey(keyFile string, passphraseEnv string) (ssh.AuthMethod, error) {
+	if keyFile == "" {
+		return nil, errors.New("no key file configured")
+	}
+
+	pemBytes, err := ioutil.ReadFile(ExpandHome(keyFile))
+	if err != nil {
+		return nil, err
+	}
+
+	if passphraseEnv != "" {
+		passphrase, ok := os.LookupEnv(passphraseEnv)
+		if ok != true {
+			return nil, errors.New("passphrase environment variable " + passphraseEnv + " is not set")
+		}
+
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", keyFile, err)
+		}
+
+		return ssh.PublicKeys(signer), nil
+	}
+
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s (encrypted keys need a passphrase environment variable)", keyFile, err)
+	}
+
+	return ssh.PublicKeys(signer), nil
+}
+
+// SSHPassword uses a password read from an environment variable.
+func SSHPassword(passwordEnv string) (ssh.AuthMethod, error) {
+	if passwordEnv == "" {
+		return nil, errors.New("no password environment variable configured")
+	}
+
+	password, ok := os.LookupEnv(passwordEnv)
+	if ok != true {
+		return nil, errors.New("password environment variable " + passwordEnv + " is not set")
+	}
+
+	return ssh.Password(password), nil
+}
+
+// ExpandHome replaces a leading ~/ in a path with the home directory
+func ExpandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home := os.Getenv("HOME"); home != "" {
+			return filepath.Join(home, path[2:])
+		}
+	}
+
+	return path
+}
@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/txn2/dmk/cfg"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// DefaultKnownHostsFile is used when a HostKey does not specify
+// a known_hosts file or fingerprint.
+var DefaultKnownHostsFile = "~/.ssh/known_hosts"
+
+// HostKeyCallback returns an ssh.HostKeyCallback verifying server
+// host keys as configured by a HostKey.
+func HostKeyCallback(hostKey cfg.HostKey) (ssh.HostKeyCallback, error) {
+	if hostKey.Insecure {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	if hostKey.Fingerprint != "" {
+		return FingerprintCallback(hostKey.Fingerprint), nil
+	}
+
+	file := hostKey.KnownHostsFile
+	if file == "" {
+		file = DefaultKnownHostsFile
+	}
+
+	callback, err := knownhosts.New(ExpandHome(file))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read known hosts: %s", err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		if ke, ok := err.(*knownhosts.KeyError); ok {
+			if len(ke.Want) == 0 {
+				return fmt.Errorf("host %s (%s) is not in %s, add it with ssh-keyscan or pin its fingerprint",
+					hostname, ssh.FingerprintSHA256(key), file)
+			}
+
+			return fmt.Errorf("host key for %s (%s) does not match %s, possible man in the middle attack",
+				hostname, ssh.FingerprintSHA256(key), file)
+		}
+
+		return err
+	}, nil
+}
+
+// FingerprintCallback returns an ssh.HostKeyCallback accepting only
+// a host key with the SHA256 fingerprint given. The "SHA256:" prefix
+// is optional.
+func FingerprintCallback(fingerprint string) ssh.HostKeyCallback {
+	want := strings.TrimPrefix(strings.TrimSpace(fingerprint), "SHA256:")
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		got := strings.TrimPrefix(ssh.FingerprintSHA256(key), "SHA256:")
+		if got != want {
+			return errors.New("host key fingerprint for " + hostname + " is SHA256:" + got +
+				", expected SHA256:" + want)
+		}
+
+		return nil
+	}
+}
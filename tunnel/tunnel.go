@@ -1,47 +1,155 @@
 package tunnel
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/txn2/dmk/cfg"
-	"github.com/davecgh/go-spew/spew"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/agent"
 )
 
+// DefaultConnectTimeout is used when a tunnel does not configure
+// a connect timeout.
+var DefaultConnectTimeout = 10 * time.Second
+
+// DefaultKeepAlive is the interval keepalive requests are sent when
+// a tunnel does not configure one.
+var DefaultKeepAlive = 30 * time.Second
+
+// maxReconnectWait is the longest wait between reconnect attempts
+var maxReconnectWait = 30 * time.Second
+
 // Manager handles the collection of tunnels
 type Manager struct {
 	// a map of of machine names to tunnels
-	tunnels map[string]*SSHTunnel
+	tunnels  map[string]*SSHTunnel
+	starting map[string]chan struct{} // closed when a starting tunnel is running or failed
+	mux      sync.Mutex
+}
+
+// NewManager creates a new tunnel manager
+func NewManager() *Manager {
+	return &Manager{
+		tunnels: make(map[string]*SSHTunnel),
+	}
 }
 
 // Tunnel opens the specified tunnel if it is not
-// alreay open.
+// alreay open. Tunnel returns once the ssh connection is
+// established and the local endpoint is accepting connections.
+// Tunnels are dialed without holding the manager lock, a caller
+// opening a tunnel that is starting waits for it.
 func (tm *Manager) Tunnel(tunnelCfg cfg.Tunnel) error {
-	// TODO: close connection when we are done.
-	// see: https://stackoverflow.com/questions/12741386/how-to-know-tcp-connection-is-closed-in-golang-net-package
-	if tm.tunnels == nil {
-		tm.tunnels = make(map[string]*SSHTunnel)
+	machineName := tunnelCfg.Component.MachineName
+
+	for {
+		tm.mux.Lock()
+
+		if tm.tunnels == nil {
+			tm.tunnels = make(map[string]*SSHTunnel)
+		}
+		if tm.starting == nil {
+			tm.starting = make(map[string]chan struct{})
+		}
+
+		// already running?
+		if _, ok := tm.tunnels[machineName]; ok {
+			tm.mux.Unlock()
+			return nil
+		}
+
+		// started by another caller?
+		if wait, ok := tm.starting[machineName]; ok {
+			tm.mux.Unlock()
+			<-wait
+			continue
+		}
+
+		break
 	}
 
-	// already running?
-	if _, ok := tm.tunnels[tunnelCfg.Component.MachineName]; ok {
-		return nil
+	started := make(chan struct{})
+	tm.starting[machineName] = started
+	tm.mux.Unlock()
+
+	defer func() {
+		tm.mux.Lock()
+		delete(tm.starting, machineName)
+		tm.mux.Unlock()
+		close(started)
+	}()
+
+	tunnel, err := newTunnel(tunnelCfg)
+	if err != nil {
+		return err
 	}
 
-	sshConfig := &ssh.ClientConfig{
-		User: tunnelCfg.TunnelAuth.User,
-		Auth: []ssh.AuthMethod{
-			SSHAgent(),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	err = tunnel.Start()
+	if err != nil {
+		closeAll(tunnel.closers)
+		return fmt.Errorf("tunnel %s: %s", machineName, err)
+	}
+
+	tm.mux.Lock()
+	tm.tunnels[machineName] = tunnel
+	tm.mux.Unlock()
+
+	return nil
+}
+
+// newTunnel returns an SSHTunnel for a tunnel configuration
+func newTunnel(tunnelCfg cfg.Tunnel) (*SSHTunnel, error) {
+	timeout := DefaultConnectTimeout
+	if tunnelCfg.ConnectTimeout > 0 {
+		timeout = time.Duration(tunnelCfg.ConnectTimeout) * time.Second
+	}
+
+	keepAlive := DefaultKeepAlive
+	if tunnelCfg.KeepAlive > 0 {
+		keepAlive = time.Duration(tunnelCfg.KeepAlive) * time.Second
+	}
+
+	// connections used by authentication methods, kept until the
+	// tunnel is closed
+	closers := make([]io.Closer, 0)
+
+	jumps := make([]*Hop, 0, len(tunnelCfg.JumpHosts))
+	for _, jumpHost := range tunnelCfg.JumpHosts {
+		jumpConfig, jumpClosers, err := clientConfig(jumpHost.TunnelAuth, jumpHost.HostKey, timeout)
+		closers = append(closers, jumpClosers...)
+		if err != nil {
+			closeAll(closers)
+			return nil, fmt.Errorf("tunnel %s: jump host %s:%d: %s", tunnelCfg.Component.MachineName,
+				jumpHost.Server.Host, jumpHost.Server.Port, err)
+		}
+
+		jumps = append(jumps, &Hop{
+			Server: &Endpoint{
+				Host: jumpHost.Server.Host,
+				Port: jumpHost.Server.Port,
+			},
+			Config: jumpConfig,
+		})
 	}
 
-	tunnel := &SSHTunnel{
-		Config: sshConfig,
+	sshConfig, sshClosers, err := clientConfig(tunnelCfg.TunnelAuth, tunnelCfg.HostKey, timeout)
+	closers = append(closers, sshClosers...)
+	if err != nil {
+		closeAll(closers)
+		return nil, err
+	}
+
+	return &SSHTunnel{
+		Name:      tunnelCfg.Component.MachineName,
+		Config:    sshConfig,
+		Jumps:     jumps,
+		KeepAlive: keepAlive,
 		Local: &Endpoint{
 			Host: tunnelCfg.Local.Host,
 			Port: tunnelCfg.Local.Port,
@@ -54,14 +162,111 @@ func (tm *Manager) Tunnel(tunnelCfg cfg.Tunnel) error {
 			Host: tunnelCfg.Remote.Host,
 			Port: tunnelCfg.Remote.Port,
 		},
+		closers: closers,
+	}, nil
+}
+
+// clientConfig returns an ssh client configuration for the
+// authentication and host key settings of a server, and the closers
+// of its authentication methods (see AuthMethods).
+func clientConfig(tunnelAuth cfg.TunnelAuth, hostKey cfg.HostKey, timeout time.Duration) (*ssh.ClientConfig, []io.Closer, error) {
+	authMethods, closers, err := AuthMethods(tunnelAuth)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// @TODO: add error chanel and close channel
-	go tunnel.Start()
+	hostKeyCallback, err := HostKeyCallback(hostKey)
+	if err != nil {
+		closeAll(closers)
+		return nil, nil, err
+	}
 
-	tm.tunnels[tunnelCfg.Component.MachineName] = tunnel
+	return &ssh.ClientConfig{
+		User:            tunnelAuth.User,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	}, closers, nil
+}
 
-	return nil
+// Running returns true if the named tunnel is running.
+func (tm *Manager) Running(machineName string) bool {
+	tm.mux.Lock()
+	defer tm.mux.Unlock()
+
+	_, ok := tm.tunnels[machineName]
+	return ok
+}
+
+// Local returns the bound local endpoint of a running tunnel.
+func (tm *Manager) Local(machineName string) (Endpoint, error) {
+	tm.mux.Lock()
+	defer tm.mux.Unlock()
+
+	tunnel, ok := tm.tunnels[machineName]
+	if ok != true {
+		return Endpoint{}, errors.New("tunnel " + machineName + " is not running")
+	}
+
+	return *tunnel.Local, nil
+}
+
+// Status returns the status of all running tunnels.
+func (tm *Manager) Status() []Status {
+	tm.mux.Lock()
+	defer tm.mux.Unlock()
+
+	status := make([]Status, 0, len(tm.tunnels))
+	for _, tunnel := range tm.tunnels {
+		status = append(status, tunnel.Status())
+	}
+
+	return status
+}
+
+// Test runs Test on a running tunnel.
+func (tm *Manager) Test(machineName string) (time.Duration, error) {
+	tm.mux.Lock()
+	tunnel, ok := tm.tunnels[machineName]
+	tm.mux.Unlock()
+
+	if ok != true {
+		return 0, errors.New("tunnel " + machineName + " is not running")
+	}
+
+	return tunnel.Test()
+}
+
+// Close stops a running tunnel.
+func (tm *Manager) Close(machineName string) error {
+	tm.mux.Lock()
+	defer tm.mux.Unlock()
+
+	tunnel, ok := tm.tunnels[machineName]
+	if ok != true {
+		return errors.New("tunnel " + machineName + " is not running")
+	}
+
+	delete(tm.tunnels, machineName)
+
+	return tunnel.Close()
+}
+
+// CloseAll stops all running tunnels.
+func (tm *Manager) CloseAll() error {
+	tm.mux.Lock()
+	defer tm.mux.Unlock()
+
+	var err error
+
+	for machineName, tunnel := range tm.tunnels {
+		if cerr := tunnel.Close(); cerr != nil {
+			err = cerr
+		}
+		delete(tm.tunnels, machineName)
+	}
+
+	return err
 }
 
 // Endpoint contains a host and port tunnel endpoint.
@@ -75,70 +280,464 @@ func (endpoint *Endpoint) String() string {
 	return fmt.Sprintf("%s:%d", endpoint.Host, endpoint.Port)
 }
 
+// Hop is an ssh server on the way to the tunnel Server.
+type Hop struct {
+	Server *Endpoint
+	Config *ssh.ClientConfig
+}
+
 // SSHTunnel holds pointers to Local, Server and Remote endpoints
-// and an ssh configuration.
+// and an ssh configuration. Jumps are connected through in order
+// before the Server.
+//
+// A single ssh client is shared by all forwarded connections. The
+// client is kept alive with keepalive requests and re-established
+// if the ssh connection drops.
 type SSHTunnel struct {
+	// counters are accessed atomically and kept first for alignment
+	bytesSent     int64 // bytes from local to remote
+	bytesReceived int64 // bytes from remote to local
+	active        int64 // open forwarded connections
+
+	Name   string
 	Local  *Endpoint
 	Server *Endpoint
 	Remote *Endpoint
 
-	Config *ssh.ClientConfig
+	Config    *ssh.ClientConfig
+	Jumps     []*Hop
+	KeepAlive time.Duration
+
+	client   *ssh.Client
+	closers  []io.Closer // connections of authentication methods
+	listener net.Listener
+	conns    map[net.Conn]struct{} // open local and remote connections
+	started  time.Time
+	closed   bool
+	done     chan struct{}
+	mux      sync.Mutex
 }
 
-// Start an ssh tunnel
+// Start an ssh tunnel. The ssh connection is established and the
+// local listener bound before Start returns, connections are then
+// accepted in the background.
 func (tunnel *SSHTunnel) Start() error {
-	fmt.Printf("Starting tunnel: %s.\n", tunnel.Local.String())
-	listener, err := net.Listen("tcp", tunnel.Local.String())
+	client, err := tunnel.dial()
 	if err != nil {
-		fmt.Printf("Got error on Local listen: %s", tunnel.Local.String())
 		return err
 	}
 
-	fmt.Printf("Accept connections...\n")
+	listener, err := net.Listen("tcp", tunnel.Local.String())
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("unable to listen on %s: %s", tunnel.Local.String(), err)
+	}
+
+	// a local port of 0 binds a free port, record the bound port
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+		tunnel.Local = &Endpoint{Host: tunnel.Local.Host, Port: addr.Port}
+	}
+
+	tunnel.client = client
+	tunnel.listener = listener
+	tunnel.conns = make(map[net.Conn]struct{})
+	tunnel.done = make(chan struct{})
+	tunnel.started = time.Now()
+
+	go tunnel.accept()
+	go tunnel.monitor(client)
+	go tunnel.keepAlive()
+
+	return nil
+}
+
+// Close stops accepting connections, closes all forwarded
+// connections and the ssh client.
+func (tunnel *SSHTunnel) Close() error {
+	tunnel.mux.Lock()
+	defer tunnel.mux.Unlock()
+
+	if tunnel.closed {
+		return nil
+	}
+
+	tunnel.closed = true
+	close(tunnel.done)
+
+	err := tunnel.listener.Close()
+
+	for conn := range tunnel.conns {
+		conn.Close()
+		delete(tunnel.conns, conn)
+	}
+
+	if cerr := tunnel.client.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	if cerr := closeAll(tunnel.closers); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// dial connects to the ssh server through any jump hosts. Each
+// hop is dialed over the ssh client of the hop before it and must
+// connect and finish its handshake within the hop's timeout. The
+// jump host clients are closed when the returned client closes.
+func (tunnel *SSHTunnel) dial() (*ssh.Client, error) {
+	hops := append(append([]*Hop{}, tunnel.Jumps...), &Hop{Server: tunnel.Server, Config: tunnel.Config})
+	clients := make([]*ssh.Client, 0, len(hops))
+
+	closeClients := func() {
+		for i := len(clients) - 1; i >= 0; i-- {
+			clients[i].Close()
+		}
+	}
+
+	for i, hop := range hops {
+		if i == 0 {
+			conn, err := net.DialTimeout("tcp", hop.Server.String(), hop.Config.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("unable to connect to ssh server %s: %s", hop.Server.String(), err)
+			}
+
+			client, err := handshake(conn, hop.Server.String(), hop.Config)
+			if err != nil {
+				return nil, fmt.Errorf("unable to connect to ssh server %s: %s", hop.Server.String(), err)
+			}
+			clients = append(clients, client)
+			continue
+		}
+
+		via := hops[i-1].Server.String()
+
+		conn, err := dialThrough(clients[i-1], hop.Server.String(), hop.Config.Timeout)
+		if err != nil {
+			closeClients()
+			return nil, fmt.Errorf("unable to reach ssh server %s through %s: %s", hop.Server.String(), via, err)
+		}
+
+		client, err := handshake(conn, hop.Server.String(), hop.Config)
+		if err != nil {
+			closeClients()
+			return nil, fmt.Errorf("unable to connect to ssh server %s through %s: %s", hop.Server.String(), via, err)
+		}
+
+		clients = append(clients, client)
+	}
+
+	client := clients[len(clients)-1]
+
+	// a dropped jump host connection also ends the final client
+	if len(clients) > 1 {
+		go func() {
+			client.Wait()
+			closeClients()
+		}()
+	}
+
+	return client, nil
+}
+
+// handshake establishes an ssh client over a connection. The
+// connection is closed if the handshake does not finish within the
+// timeout of the client configuration.
+func handshake(conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	var timer *time.Timer
+	if config.Timeout > 0 {
+		timer = time.AfterFunc(config.Timeout, func() { conn.Close() })
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+
+	if timer != nil && timer.Stop() == false {
+		if sshConn != nil {
+			sshConn.Close()
+		}
+		return nil, fmt.Errorf("ssh handshake timed out after %s", config.Timeout)
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// dialThrough opens a connection to addr through an ssh client,
+// giving up after timeout unless it is 0.
+func dialThrough(client *ssh.Client, addr string, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		return client.Dial("tcp", addr)
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
 
-	defer listener.Close()
+	result := make(chan dialResult, 1)
+	go func() {
+		conn, err := client.Dial("tcp", addr)
+		result <- dialResult{conn: conn, err: err}
+	}()
 
+	select {
+	case r := <-result:
+		return r.conn, r.err
+	case <-time.After(timeout):
+		// close a connection made after giving up
+		go func() {
+			if r := <-result; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// Route returns the ssh servers of the tunnel in connection order.
+func (tunnel *SSHTunnel) Route() []string {
+	route := make([]string, 0, len(tunnel.Jumps)+1)
+	for _, hop := range tunnel.Jumps {
+		route = append(route, hop.Server.String())
+	}
+	return append(route, tunnel.Server.String())
+}
+
+// sshClient returns the current ssh client
+func (tunnel *SSHTunnel) sshClient() *ssh.Client {
+	tunnel.mux.Lock()
+	defer tunnel.mux.Unlock()
+	return tunnel.client
+}
+
+// accept local connections and forward them
+func (tunnel *SSHTunnel) accept() {
 	for {
-		conn, err := listener.Accept()
+		conn, err := tunnel.listener.Accept()
 		if err != nil {
-			spew.Dump("Error on connection...\n")
-			return err
+			return
 		}
 		go tunnel.forward(conn)
 	}
 }
 
+// keepAlive sends keepalive requests on an interval, closing the
+// client if the server does not respond within the connect timeout
+// so monitor reconnects.
+func (tunnel *SSHTunnel) keepAlive() {
+	if tunnel.KeepAlive <= 0 {
+		return
+	}
+
+	timeout := tunnel.Config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultConnectTimeout
+	}
+
+	ticker := time.NewTicker(tunnel.KeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-tunnel.done:
+			return
+		case <-ticker.C:
+			client := tunnel.sshClient()
+
+			reply := make(chan error, 1)
+			go func() {
+				_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+				reply <- err
+			}()
+
+			select {
+			case err := <-reply:
+				if err != nil {
+					client.Close()
+				}
+			case <-time.After(timeout):
+				// closing the client also ends the pending request
+				client.Close()
+			case <-tunnel.done:
+				return
+			}
+		}
+	}
+}
+
+// monitor waits for the ssh client connection to drop and
+// reconnects until the tunnel is closed.
+func (tunnel *SSHTunnel) monitor(client *ssh.Client) {
+	for {
+		client.Wait()
+
+		select {
+		case <-tunnel.done:
+			return
+		default:
+		}
+
+		fmt.Printf("Tunnel %s lost its ssh connection, reconnecting.\n", tunnel.Name)
+
+		wait := time.Second
+
+		for {
+
+			var err error
+			client, err = tunnel.dial()
+			if err == nil {
+				break
+			}
+
+			fmt.Printf("Tunnel %s reconnect error: %s\n", tunnel.Name, err)
+
+			select {
+			case <-tunnel.done:
+				return
+			case <-time.After(wait):
+			}
+
+			if wait *= 2; wait > maxReconnectWait {
+				wait = maxReconnectWait
+			}
+		}
+
+		tunnel.mux.Lock()
+		if tunnel.closed {
+			tunnel.mux.Unlock()
+			client.Close()
+			return
+		}
+
+		// connections forwarded over the old client are dead
+		for conn := range tunnel.conns {
+			conn.Close()
+			delete(tunnel.conns, conn)
+		}
+		tunnel.client = client
+		tunnel.mux.Unlock()
+
+		fmt.Printf("Tunnel %s reconnected.\n", tunnel.Name)
+	}
+}
+
+// track records open connections so they can be closed with the
+// tunnel, false is returned if the tunnel is closed.
+func (tunnel *SSHTunnel) track(conns ...net.Conn) bool {
+	tunnel.mux.Lock()
+	defer tunnel.mux.Unlock()
+
+	if tunnel.closed {
+		return false
+	}
+
+	for _, conn := range conns {
+		tunnel.conns[conn] = struct{}{}
+	}
+
+	return true
+}
+
+// untrack closes and forgets connections
+func (tunnel *SSHTunnel) untrack(conns ...net.Conn) {
+	tunnel.mux.Lock()
+	defer tunnel.mux.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+		delete(tunnel.conns, conn)
+	}
+}
+
 // forward a connection
 func (tunnel *SSHTunnel) forward(localConn net.Conn) {
-	serverConn, err := ssh.Dial("tcp", tunnel.Server.String(), tunnel.Config)
+	remoteConn, err := tunnel.sshClient().Dial("tcp", tunnel.Remote.String())
 	if err != nil {
-		fmt.Printf("Server dial error: %s\n", err)
+		fmt.Printf("Remote dial error: %s\n", err)
+		localConn.Close()
 		return
 	}
 
-	remoteConn, err := serverConn.Dial("tcp", tunnel.Remote.String())
-	if err != nil {
-		fmt.Printf("Remote dial error: %s\n", err)
+	if tunnel.track(localConn, remoteConn) == false {
+		localConn.Close()
+		remoteConn.Close()
 		return
 	}
 
-	copyConn := func(writer, reader net.Conn) {
-		_, err := io.Copy(writer, reader)
-		if err != nil {
-			fmt.Printf("io.Copy error: %s", err)
-		}
+	atomic.AddInt64(&tunnel.active, 1)
+
+	// when either side finishes both halves are closed
+	var once sync.Once
+	closeConns := func() {
+		tunnel.untrack(localConn, remoteConn)
+		atomic.AddInt64(&tunnel.active, -1)
+	}
+
+	copyConn := func(writer, reader net.Conn, counter *int64) {
+		io.Copy(&countWriter{writer: writer, count: counter}, reader)
+		once.Do(closeConns)
 	}
 
-	go copyConn(localConn, remoteConn)
-	go copyConn(remoteConn, localConn)
+	go copyConn(localConn, remoteConn, &tunnel.bytesReceived)
+	go copyConn(remoteConn, localConn, &tunnel.bytesSent)
 }
 
-// SSHAgent uses the exposed ssh agent from SSH_AUTH_SOCK
-// TODO: Allow an ssh key to be specified (for automated / server runs)
-func SSHAgent() ssh.AuthMethod {
-	if sshAgent, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK")); err == nil {
-		return ssh.PublicKeysCallback(agent.NewClient(sshAgent).Signers)
+// Status describes a running tunnel
+type Status struct {
+	Name          string
+	Local         string
+	Server        string // ssh servers, jump hosts first, separated by " > "
+	Remote        string
+	Started       time.Time
+	Connections   int64 // open forwarded connections
+	BytesSent     int64 // bytes from local to remote
+	BytesReceived int64 // bytes from remote to local
+}
+
+// countWriter counts bytes written to writer
+type countWriter struct {
+	writer io.Writer
+	count  *int64
+}
+
+// Write for io.Writer interface.
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.writer.Write(p)
+	atomic.AddInt64(cw.count, int64(n))
+	return n, err
+}
+
+// Status returns the current status of the tunnel.
+func (tunnel *SSHTunnel) Status() Status {
+	return Status{
+		Name:          tunnel.Name,
+		Local:         tunnel.Local.String(),
+		Server:        strings.Join(tunnel.Route(), " > "),
+		Remote:        tunnel.Remote.String(),
+		Started:       tunnel.started,
+		Connections:   atomic.LoadInt64(&tunnel.active),
+		BytesSent:     atomic.LoadInt64(&tunnel.bytesSent),
+		BytesReceived: atomic.LoadInt64(&tunnel.bytesReceived),
 	}
+}
 
-	return nil
+// Test opens and closes a connection to the remote endpoint over
+// the ssh connection, returning the time it took.
+func (tunnel *SSHTunnel) Test() (time.Duration, error) {
+	start := time.Now()
+
+	conn, err := tunnel.sshClient().Dial("tcp", tunnel.Remote.String())
+	if err != nil {
+		return 0, fmt.Errorf("unable to reach %s through %s: %s", tunnel.Remote.String(), tunnel.Server.String(), err)
+	}
+
+	elapsed := time.Now().Sub(start)
+	conn.Close()
+
+	return elapsed, nil
 }